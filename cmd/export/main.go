@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"syscall"
 
 	"github.com/spf13/pflag"
@@ -21,7 +26,13 @@ func main() {
 	defer cancel()
 
 	var (
-		labelName           string
+		labelNames          []string
+		rawQuery            string
+		after               string
+		before              string
+		from                string
+		hasAttachment       bool
+		largerThan          string
 		limit               int64
 		credentialsPath     string
 		downloadAttachments bool
@@ -29,71 +40,384 @@ func main() {
 		outputFile          string
 		removeImg           bool
 		removeLink          bool
+		concurrency         int64
+		batchSize           int64
+		resume              bool
+		checkpointFile      string
+		incremental         bool
+		stateFile           string
+		maxRetries          int64
+		quotaRate           int64
+		byThread            bool
+		includeRaw          bool
+		includeParts        bool
+		rawDir              string
+		format              string
+		compression         string
+		csvColumns          []string
+		csvSeparator        string
+		markdownGroup       string
+		esIndex             string
+		esURL               string
 	)
 
-	pflag.StringVar(&labelName, "label", utils.GetEnvWithDefault("GMAIL_LABEL", "INBOX"), "Gmail label name to filter emails (env: GMAIL_LABEL)")
+	pflag.StringSliceVar(&labelNames, "label", utils.GetEnvWithDefault("GMAIL_LABEL", []string{"INBOX"}), "Gmail label names to filter emails, repeat to require several labels (env: GMAIL_LABEL)")
+	pflag.StringVar(&rawQuery, "query", utils.GetEnvWithDefault("GMAIL_QUERY", ""), "Raw Gmail search query, combined with the other filters (env: GMAIL_QUERY)")
+	pflag.StringVar(&after, "after", utils.GetEnvWithDefault("GMAIL_AFTER", ""), "Only export emails received on or after this YYYY-MM-DD date (env: GMAIL_AFTER)")
+	pflag.StringVar(&before, "before", utils.GetEnvWithDefault("GMAIL_BEFORE", ""), "Only export emails received before this YYYY-MM-DD date (env: GMAIL_BEFORE)")
+	pflag.StringVar(&from, "from", utils.GetEnvWithDefault("GMAIL_FROM", ""), "Only export emails from this sender (env: GMAIL_FROM)")
+	pflag.BoolVar(&hasAttachment, "has-attachment", utils.GetEnvWithDefault("GMAIL_HAS_ATTACHMENT", false), "Only export emails with attachments (env: GMAIL_HAS_ATTACHMENT)")
+	pflag.StringVar(&largerThan, "larger-than", utils.GetEnvWithDefault("GMAIL_LARGER_THAN", ""), "Only export emails larger than this size in bytes, e.g. 500K or 5M (env: GMAIL_LARGER_THAN)")
 	pflag.Int64Var(&limit, "limit", utils.GetEnvWithDefault("GMAIL_LIMIT", int64(500)), "Maximum number of emails to retrieve (env: GMAIL_LIMIT)")
 	pflag.StringVar(&credentialsPath, "credentials-file", utils.GetEnvWithDefault("GMAIL_CREDENTIALS_FILE", "credentials.json"), "Path to credentials.json file (env: GMAIL_CREDENTIALS_FILE)")
 	pflag.BoolVar(&downloadAttachments, "download-attachments", utils.GetEnvWithDefault("GMAIL_DOWNLOAD_ATTACHMENTS", false), "Download all attachments from retrieved emails (env: GMAIL_DOWNLOAD_ATTACHMENTS)")
-	pflag.StringVar(&attachmentsDir, "attachments-dir", utils.GetEnvWithDefault("GMAIL_ATTACHMENTS_DIR", "attachments"), "Directory path to save attachments (env: GMAIL_ATTACHMENTS_DIR)")
-	pflag.StringVar(&outputFile, "output", utils.GetEnvWithDefault("GMAIL_OUTPUT_FILE", "emails.jsonl"), "Output JSONL file path (env: GMAIL_OUTPUT_FILE)")
+	pflag.StringVar(&attachmentsDir, "attachments-dir", utils.GetEnvWithDefault("GMAIL_ATTACHMENTS_DIR", "attachments"), "Directory path to save attachments, <output>/attachments by default with the html-site format (env: GMAIL_ATTACHMENTS_DIR)")
+	pflag.StringVar(&outputFile, "output", utils.GetEnvWithDefault("GMAIL_OUTPUT_FILE", "emails.jsonl"), "Output file path, - writes to the standard output (env: GMAIL_OUTPUT_FILE)")
 	pflag.BoolVar(&removeImg, "markdown-strip-img", utils.GetEnvWithDefault("GMAIL_STRIP_IMG", false), "Remove <img> tags from markdown output (env: GMAIL_STRIP_IMG)")
 	pflag.BoolVar(&removeLink, "markdown-strip-link", utils.GetEnvWithDefault("GMAIL_STRIP_LINK", false), "Remove links from markdown output, keeping only the label (env: GMAIL_STRIP_LINK)")
+	pflag.Int64Var(&concurrency, "concurrency", utils.GetEnvWithDefault("GMAIL_CONCURRENCY", int64(gmail.DefaultConcurrency)), "Number of emails fetched in parallel (env: GMAIL_CONCURRENCY)")
+	pflag.Int64Var(&batchSize, "batch-size", utils.GetEnvWithDefault("GMAIL_BATCH_SIZE", int64(gmail.DefaultBatchSize)), "Number of emails fetched per batch request, 0 disables batching (env: GMAIL_BATCH_SIZE)")
+	pflag.BoolVar(&resume, "resume", utils.GetEnvWithDefault("GMAIL_RESUME", false), "Resume an interrupted export from its checkpoint, appending to the output file (env: GMAIL_RESUME)")
+	pflag.StringVar(&checkpointFile, "checkpoint-file", utils.GetEnvWithDefault("GMAIL_CHECKPOINT_FILE", ""), "Checkpoint file path, defaults to the output file path with a .checkpoint suffix (env: GMAIL_CHECKPOINT_FILE)")
+	pflag.BoolVar(&incremental, "incremental", utils.GetEnvWithDefault("GMAIL_INCREMENTAL", false), "Append only the changes made since the previous incremental export (env: GMAIL_INCREMENTAL)")
+	pflag.StringVar(&stateFile, "state-file", utils.GetEnvWithDefault("GMAIL_STATE_FILE", ""), "Sync state file path for incremental exports, defaults to the output file path with a .state suffix (env: GMAIL_STATE_FILE)")
+	pflag.Int64Var(&maxRetries, "max-retries", utils.GetEnvWithDefault("GMAIL_MAX_RETRIES", int64(gmail.DefaultMaxRetries)), "Number of times a request failing with a rate limit or server error is retried (env: GMAIL_MAX_RETRIES)")
+	pflag.Int64Var(&quotaRate, "quota-rate", utils.GetEnvWithDefault("GMAIL_QUOTA_RATE", int64(gmail.DefaultQuotaRate)), "Maximum Gmail API quota units consumed per second, 0 disables rate limiting (env: GMAIL_QUOTA_RATE)")
+	pflag.BoolVar(&byThread, "by-thread", utils.GetEnvWithDefault("GMAIL_BY_THREAD", false), "Export one record per conversation, applying the limit to threads (env: GMAIL_BY_THREAD)")
+	pflag.BoolVar(&includeRaw, "include-raw", utils.GetEnvWithDefault("GMAIL_INCLUDE_RAW", false), "Include raw RFC822 message in base64 (env: GMAIL_INCLUDE_RAW)")
+	pflag.BoolVar(&includeParts, "include-parts", utils.GetEnvWithDefault("GMAIL_INCLUDE_PARTS", false), "Include the MIME structure of each email as a parts array (env: GMAIL_INCLUDE_PARTS)")
+	pflag.StringVar(&rawDir, "raw-dir", utils.GetEnvWithDefault("GMAIL_RAW_DIR", ""), "Write raw RFC822 messages to .eml files in this directory instead of inlining them, requires --include-raw (env: GMAIL_RAW_DIR)")
+	pflag.StringVar(&format, "format", utils.GetEnvWithDefault("GMAIL_FORMAT", gmail.FormatJSONL), "Output format: jsonl, mbox, maildir, eml, sqlite, csv, parquet, markdown-dir, html-site or es-bulk; maildir, eml, markdown-dir and html-site write to the --output directory (env: GMAIL_FORMAT)")
+	pflag.StringVar(&compression, "compress", utils.GetEnvWithDefault("GMAIL_COMPRESS", ""), "Compress the output file: gzip or zstd, inferred from a .gz or .zst output file extension by default (env: GMAIL_COMPRESS)")
+	pflag.StringSliceVar(&csvColumns, "csv-columns", utils.GetEnvWithDefault("GMAIL_CSV_COLUMNS", gmail.DefaultCSVColumns), "Columns written by the csv format; body_text, body_markdown, body_html, bcc and date_source are also available (env: GMAIL_CSV_COLUMNS)")
+	pflag.StringVar(&csvSeparator, "csv-separator", utils.GetEnvWithDefault("GMAIL_CSV_SEPARATOR", gmail.DefaultCSVSeparator), "Separator joining multi-valued csv fields such as recipients and labels (env: GMAIL_CSV_SEPARATOR)")
+	pflag.StringVar(&markdownGroup, "markdown-group", utils.GetEnvWithDefault("GMAIL_MARKDOWN_GROUP", gmail.MarkdownGroupThread), "Folders of the markdown-dir format: thread or date (env: GMAIL_MARKDOWN_GROUP)")
+	pflag.StringVar(&esIndex, "es-index", utils.GetEnvWithDefault("GMAIL_ES_INDEX", gmail.DefaultESIndex), "Index named in the actions of the es-bulk format (env: GMAIL_ES_INDEX)")
+	pflag.StringVar(&esURL, "es-url", utils.GetEnvWithDefault("GMAIL_ES_URL", ""), "Elasticsearch or OpenSearch URL the es-bulk actions are pushed to instead of writing them to the output file (env: GMAIL_ES_URL)")
 	pflag.Parse()
 
-	service, err := auth.GetGmailService(ctx, credentialsPath)
-	if err != nil {
-		slog.Error("Failed to get Gmail service", "error", err)
+	filter := gmail.QueryFilter{
+		Query:         rawQuery,
+		LabelIDs:      labelNames,
+		After:         after,
+		Before:        before,
+		From:          from,
+		HasAttachment: hasAttachment,
+		LargerThan:    largerThan,
+	}
+	if _, err := filter.Build(); err != nil {
+		slog.Error("Invalid search criteria", "error", err)
+		os.Exit(1)
+	}
+	if rawDir != "" && !includeRaw {
+		slog.Error("--raw-dir requires --include-raw")
+		os.Exit(1)
+	}
+	if byThread && format != gmail.FormatJSONL {
+		slog.Error("--by-thread is only supported with the jsonl format")
+		os.Exit(1)
+	}
+	if incremental && byThread {
+		slog.Error("Incremental exports cannot be combined with --by-thread")
+		os.Exit(1)
+	}
+	if format == gmail.FormatParquet && (resume || incremental) {
+		slog.Error("Parquet exports cannot be resumed or incremental")
+		os.Exit(1)
+	}
+	if incremental && !filter.IsLabelOnly() {
+		slog.Error("Incremental exports require a single --label and no other search criteria")
 		os.Exit(1)
 	}
 
-	client := gmail.NewClient(service)
+	toStdout := outputFile == gmail.StdoutOutput
+	if toStdout && resume {
+		slog.Error("Exports to the standard output cannot be resumed")
+		os.Exit(1)
+	}
+	if toStdout && incremental && stateFile == "" {
+		slog.Error("Incremental exports to the standard output require --state-file")
+		os.Exit(1)
+	}
+	if compression == "" {
+		compression = gmail.CompressionForPath(outputFile)
+	}
 
-	slog.Info("Fetching emails",
-		"label", labelName,
-		"limit", limit,
-		"markdown_strip_img", removeImg,
-		"markdown_strip_link", removeLink)
+	if checkpointFile == "" {
+		checkpointFile = outputFile + ".checkpoint"
+	}
+	if stateFile == "" {
+		stateFile = outputFile + ".state"
+	}
+	if format == gmail.FormatHTMLSite && !pflag.CommandLine.Changed("attachments-dir") && os.Getenv("GMAIL_ATTACHMENTS_DIR") == "" {
+		// Sites are shared as a directory, keep their attachments inside
+		attachmentsDir = filepath.Join(outputFile, gmail.SiteAttachmentsDir)
+	}
 
-	query := ""
-	if labelName != "" {
-		labelID, err := client.GetLabelID(ctx, labelName)
-		if err != nil {
-			query = "label:" + labelName
-		} else {
-			query = "label:" + labelID
-		}
+	httpClient, err := auth.GetHTTPClient(ctx, credentialsPath)
+	if err != nil {
+		slog.Error("Failed to get Gmail service", "error", err)
+		os.Exit(1)
 	}
 
-	messages, err := client.GetMessagesByQuery(ctx, query, limit)
+	client, err := gmail.NewClient(ctx, httpClient, gmail.ClientOptions{
+		Concurrency: int(concurrency),
+		BatchSize:   int(batchSize),
+		MaxRetries:  int(maxRetries),
+		QuotaRate:   float64(quotaRate),
+		IncludeRaw:  includeRaw || gmail.FormatRequiresRaw(format),
+	})
 	if err != nil {
-		slog.Error("Failed to get emails", "error", err)
+		slog.Error("Failed to create Gmail client", "error", err)
 		os.Exit(1)
 	}
 
-	if len(messages) == 0 {
-		slog.Info("No emails found with the specified criteria", "label", labelName)
-		return
+	// Search by label ID, falling back to the name for labels that cannot
+	// be resolved
+	labelIDs := make([]string, 0, len(labelNames))
+	for _, labelName := range labelNames {
+		if labelName == "" {
+			continue
+		}
+		labelID := labelName
+		if id, err := client.GetLabelID(ctx, labelName); err == nil {
+			labelID = id
+		}
+		labelIDs = append(labelIDs, labelID)
 	}
+	filter.LabelIDs = labelIDs
 
-	slog.Info("Found emails", "count", len(messages))
+	query, err := filter.Build()
+	if err != nil {
+		slog.Error("Invalid search criteria", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("Fetching emails",
+		"query", query,
+		"limit", limit,
+		"concurrency", concurrency,
+		"batch_size", batchSize,
+		"quota_rate", quotaRate,
+		"markdown_strip_img", removeImg,
+		"markdown_strip_link", removeLink,
+		"format", format,
+		"by_thread", byThread,
+		"include_raw", includeRaw)
 
 	exportOptions := gmail.ExportOptions{
+		Format:             format,
+		Compression:        compression,
 		OutputFile:         outputFile,
 		IncludeAttachments: downloadAttachments,
 		AttachmentsDir:     attachmentsDir,
 		StripImages:        removeImg,
 		StripLinks:         removeLink,
+		IncludeRaw:         includeRaw,
+		IncludeParts:       includeParts,
+		RawDir:             rawDir,
+		CSVColumns:         csvColumns,
+		CSVSeparator:       csvSeparator,
+		MarkdownGroup:      markdownGroup,
+		ESIndex:            esIndex,
+		ESURL:              esURL,
+		MaxRetries:         int(maxRetries),
 	}
 
-	if err := gmail.ExportToJSONL(ctx, client, messages, exportOptions); err != nil {
-		slog.Error("Failed to export emails", "error", err)
-		os.Exit(1)
+	switch format {
+	case gmail.FormatEML, gmail.FormatCSV, gmail.FormatMarkdownDir, gmail.FormatHTMLSite:
+		exportOptions.LabelNames, err = client.GetLabelNames(ctx)
+		if err != nil {
+			slog.Error("Failed to get label names", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if incremental {
+		state, err := gmail.LoadSyncState(stateFile)
+		if err != nil {
+			slog.Error("Failed to load sync state", "error", err)
+			os.Exit(1)
+		}
+
+		if state != nil {
+			if state.LabelID != labelIDs[0] {
+				slog.Error("Sync state was created for another label", "state_label", state.LabelID, "label", labelIDs[0])
+				os.Exit(1)
+			}
+			exportIncremental(ctx, client, state, stateFile, checkpointFile, exportOptions)
+			return
+		}
+
+		slog.Info("No sync state found, running a full export", "state", stateFile)
+
+		// Later runs only fetch the changes made after this export, so the
+		// emails left out by a limit would never be exported
+		if pflag.CommandLine.Changed("limit") || os.Getenv("GMAIL_LIMIT") != "" {
+			slog.Warn("Ignoring --limit for the first incremental export", "limit", limit)
+		}
+		limit = math.MaxInt64
+	}
+
+	// Thread exports record thread IDs, so they must not resume from a
+	// checkpoint of a message export for the same query
+	checkpointKey := query
+	if byThread {
+		checkpointKey = "threads:" + query
+	}
+
+	checkpoint := gmail.NewCheckpoint(checkpointFile, checkpointKey)
+	if resume {
+		checkpoint, err = gmail.LoadCheckpoint(checkpointFile, checkpointKey)
+		if err != nil {
+			slog.Error("Failed to load checkpoint", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Resuming export", "checkpoint", checkpointFile, "already_exported", len(checkpoint.ExportedIDs))
+	}
+
+	// Capture the history ID before listing messages so that the next
+	// incremental export picks up changes made while this one runs
+	if incremental && checkpoint.HistoryID == 0 {
+		checkpoint.HistoryID, err = client.GetHistoryID(ctx)
+		if err != nil {
+			slog.Error("Failed to get mailbox history ID", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	count := 0
+	remaining := limit - int64(len(checkpoint.ExportedIDs))
+	if remaining > 0 {
+		// Output written to the standard output cannot be resumed, so it
+		// is not checkpointed
+		if !toStdout {
+			exportOptions.Checkpoint = checkpoint
+		}
+		exportOptions.Resume = resume
+
+		queryOptions := gmail.QueryOptions{
+			Limit:     remaining,
+			PageToken: checkpoint.PageToken,
+			Skip:      checkpoint.Exported,
+			OnPage:    checkpoint.SetPageToken,
+		}
+
+		if byThread {
+			threads := client.StreamThreadsByQuery(ctx, query, queryOptions)
+			count, err = gmail.ExportThreadsToJSONL(ctx, client, threads, exportOptions)
+		} else {
+			messages := client.StreamMessagesByQuery(ctx, query, queryOptions)
+			count, err = gmail.Export(ctx, client, messages, exportOptions)
+		}
+		if err != nil {
+			slog.Error("Failed to export emails", "error", err, "exported", count)
+			if !toStdout {
+				slog.Info("Export can be resumed with --resume", "checkpoint", checkpointFile)
+			}
+			os.Exit(1)
+		}
+	} else {
+		slog.Info("Export already reached the limit", "limit", limit, "output", outputFile)
+		if err := checkpoint.Remove(); err != nil {
+			slog.Warn("Failed to remove checkpoint", "error", err)
+		}
+	}
+
+	if incremental {
+		state := &gmail.SyncState{HistoryID: checkpoint.HistoryID, LabelID: labelIDs[0]}
+		if err := state.Save(stateFile); err != nil {
+			slog.Error("Failed to save sync state", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Saved sync state for incremental exports", "state", stateFile, "history_id", state.HistoryID)
+	}
+
+	if count == 0 {
+		slog.Info("No emails found with the specified criteria", "query", query)
+		return
 	}
 
 	slog.Info("Successfully exported emails",
-		"count", len(messages),
+		"count", count,
 		"output", outputFile,
 		"attachments_downloaded", downloadAttachments,
 	)
 }
+
+// exportIncremental appends the emails added since the sync state's history
+// ID to the output file, followed by deletion and label change events, and
+// advances the sync state
+func exportIncremental(ctx context.Context, client *gmail.Client, state *gmail.SyncState, stateFile, checkpointFile string, exportOptions gmail.ExportOptions) {
+	slog.Info("Fetching mailbox changes", "label", state.LabelID, "history_id", state.HistoryID)
+
+	changes, err := client.ListHistory(ctx, state.HistoryID, state.LabelID)
+	if err != nil {
+		if errors.Is(err, gmail.ErrHistoryExpired) {
+			slog.Error("Sync state is too old, remove it to run a full export", "state", stateFile)
+		}
+		slog.Error("Failed to get mailbox changes", "error", err)
+		os.Exit(1)
+	}
+
+	exportOptions.Append = true
+	exportOptions.Events = changes.Events
+
+	// A checkpoint left by a failed run for the same changes records the
+	// emails already appended, which must not be appended again. Output
+	// written to the standard output is not checkpointed.
+	addedIDs := changes.AddedIDs
+	if exportOptions.OutputFile != gmail.StdoutOutput {
+		checkpointKey := fmt.Sprintf("history:%s:%d", state.LabelID, state.HistoryID)
+		checkpoint, err := gmail.LoadCheckpoint(checkpointFile, checkpointKey)
+		if err != nil && !errors.Is(err, gmail.ErrNoCheckpoint) {
+			slog.Error("Failed to load checkpoint", "error", err)
+			os.Exit(1)
+		}
+
+		if err == nil {
+			// The output is truncated to the size recorded in the
+			// checkpoint, which includes the content written before the
+			// failed run
+			exportOptions.Append = false
+			exportOptions.Resume = true
+			addedIDs = slices.DeleteFunc(slices.Clone(addedIDs), checkpoint.Exported)
+			slog.Info("Resuming incremental export", "checkpoint", checkpointFile, "already_exported", len(checkpoint.ExportedIDs))
+		} else {
+			// Save the current end of the output before appending, so that
+			// a run killed before its first checkpoint is resumed too
+			checkpoint = gmail.NewCheckpoint(checkpointFile, checkpointKey)
+			var size int64
+			if info, err := os.Stat(exportOptions.OutputFile); err == nil && info.Mode().IsRegular() {
+				size = info.Size()
+			}
+			if err := checkpoint.Save(size); err != nil {
+				slog.Error("Failed to save checkpoint", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	messages := client.StreamMessagesByID(ctx, addedIDs)
+	count, err := gmail.Export(ctx, client, messages, exportOptions)
+	if err != nil {
+		slog.Error("Failed to export emails", "error", err, "exported", count)
+		if exportOptions.OutputFile != gmail.StdoutOutput {
+			slog.Info("Rerun the incremental export to resume it", "checkpoint", checkpointFile)
+		}
+		os.Exit(1)
+	}
+
+	state.HistoryID = changes.HistoryID
+	if err := state.Save(stateFile); err != nil {
+		slog.Error("Failed to save sync state", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("Successfully exported mailbox changes",
+		"added", count,
+		"events", len(changes.Events),
+		"output", exportOptions.OutputFile,
+		"history_id", state.HistoryID,
+	)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/f-pisani/gmail-cli-tools/internal/gmail"
+	"github.com/f-pisani/gmail-cli-tools/internal/utils"
+)
+
+func main() {
+	utils.InitLogger()
+
+	var (
+		outputFile   string
+		validateFile string
+	)
+
+	pflag.StringVar(&outputFile, "output", utils.GetEnvWithDefault("GMAIL_SCHEMA_OUTPUT", ""), "Write the JSON Schema to this file instead of the standard output (env: GMAIL_SCHEMA_OUTPUT)")
+	pflag.StringVar(&validateFile, "validate", utils.GetEnvWithDefault("GMAIL_SCHEMA_VALIDATE", ""), "Validate the records of this JSONL export against the JSON Schema instead of printing it, - reads the standard input (env: GMAIL_SCHEMA_VALIDATE)")
+	pflag.Parse()
+
+	if validateFile != "" {
+		validate(validateFile)
+		return
+	}
+
+	schema, err := gmail.JSONSchema()
+	if err != nil {
+		slog.Error("Failed to generate schema", "error", err)
+		os.Exit(1)
+	}
+
+	if outputFile == "" {
+		if _, err := os.Stdout.Write(schema); err != nil {
+			slog.Error("Failed to write schema", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := os.WriteFile(outputFile, schema, 0644); err != nil {
+		slog.Error("Failed to write schema", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Wrote JSON Schema", "output", outputFile, "schema_version", gmail.SchemaVersion)
+}
+
+// validate checks every record of a JSONL export and exits with an error
+// when any of them does not match the schema
+func validate(path string) {
+	input, err := gmail.OpenInput(path)
+	if err != nil {
+		slog.Error("Failed to open export", "error", err)
+		os.Exit(1)
+	}
+	defer input.Close()
+
+	records, invalid, err := gmail.ValidateJSONL(input, func(line int, err error) {
+		slog.Error("Invalid record", "line", line, "error", err)
+	})
+	if err != nil {
+		slog.Error("Failed to validate export", "error", err)
+		os.Exit(1)
+	}
+
+	if invalid > 0 {
+		slog.Error("Export does not match the schema", "records", records, "invalid", invalid, "schema_version", gmail.SchemaVersion)
+		os.Exit(1)
+	}
+	slog.Info("Export matches the schema", "records", records, "schema_version", gmail.SchemaVersion)
+}
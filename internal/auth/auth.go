@@ -169,7 +169,8 @@ func saveToken(path string, token *oauth2.Token) error {
 	return json.NewEncoder(f).Encode(token)
 }
 
-func GetGmailService(ctx context.Context, credentialsFile string) (*gmail.Service, error) {
+// GetHTTPClient returns an authenticated HTTP client for the Gmail API
+func GetHTTPClient(ctx context.Context, credentialsFile string) (*http.Client, error) {
 	jsonKey, err := os.ReadFile(credentialsFile)
 	if err != nil {
 		return nil, err
@@ -180,7 +181,11 @@ func GetGmailService(ctx context.Context, credentialsFile string) (*gmail.Servic
 		return nil, err
 	}
 
-	client, err := getClientWithTokenSource(ctx, config)
+	return getClientWithTokenSource(ctx, config)
+}
+
+func GetGmailService(ctx context.Context, credentialsFile string) (*gmail.Service, error) {
+	client, err := GetHTTPClient(ctx, credentialsFile)
 	if err != nil {
 		return nil, err
 	}
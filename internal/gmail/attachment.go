@@ -2,25 +2,33 @@ package gmail
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"google.golang.org/api/gmail/v1"
 )
 
 func (c *Client) DownloadAttachment(ctx context.Context, messageID, attachmentID, filename, outputDir string) error {
 	user := "me"
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
-	}
-
-	attachment, err := c.service.Users.Messages.Attachments.Get(user, messageID, attachmentID).Context(ctx).Do()
+	attachment, err := withRetry(ctx, c, quotaAttachmentsGet, func() (*gmail.MessagePartBody, error) {
+		return c.service.Users.Messages.Attachments.Get(user, messageID, attachmentID).Context(ctx).Do()
+	})
 	if err != nil {
 		return fmt.Errorf("failed to get attachment: %v", err)
 	}
 
-	data, err := base64.URLEncoding.DecodeString(attachment.Data)
+	return saveAttachment(attachment.Data, filename, outputDir)
+}
+
+// saveAttachment decodes base64url attachment data into a file
+func saveAttachment(encoded, filename, outputDir string) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	data, err := decodeBase64URL(encoded)
 	if err != nil {
 		return fmt.Errorf("failed to decode attachment: %v", err)
 	}
@@ -0,0 +1,144 @@
+package gmail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// MaxBatchSize is the maximum number of sub-requests accepted by the Gmail
+// batch endpoint
+const MaxBatchSize = 100
+
+// DefaultBatchSize is the number of messages fetched per batch request when
+// no batch size is configured. Gmail recommends staying at or below 50 to
+// avoid rate limiting.
+const DefaultBatchSize = 50
+
+// batchResult holds the outcome of a single sub-request of a batch
+type batchResult[T any] struct {
+	value T
+	err   error
+}
+
+// batchGet sends up to MaxBatchSize GET sub-requests, given as paths relative
+// to the user, with a single multipart/mixed request to the Gmail batch
+// endpoint. The returned results are indexed like paths; an error is
+// returned only when the batch request itself fails.
+func batchGet[T any](ctx context.Context, c *Client, paths []string) ([]batchResult[T], error) {
+	if len(paths) > MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d requests exceeds maximum of %d", len(paths), MaxBatchSize)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, path := range paths {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<item%d>", i))
+
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create batch part: %w", err)
+		}
+		if _, err := fmt.Fprintf(part, "GET /gmail/v1/users/me/%s\r\n\r\n", path); err != nil {
+			return nil, fmt.Errorf("failed to write batch part: %w", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close batch body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.service.BasePath+"batch/gmail/v1", &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch request: %w", err)
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("batch request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	results := make([]batchResult[T], len(paths))
+	seen := make([]bool, len(paths))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read batch response: %w", err)
+		}
+
+		i, err := parseBatchContentID(part.Header.Get("Content-ID"))
+		if err != nil || i < 0 || i >= len(paths) {
+			continue
+		}
+
+		seen[i] = true
+		results[i] = readBatchPart[T](part)
+	}
+
+	for i := range results {
+		if !seen[i] {
+			results[i].err = fmt.Errorf("no response for %s in batch", paths[i])
+		}
+	}
+
+	return results, nil
+}
+
+// readBatchPart decodes the HTTP response embedded in a batch response part
+func readBatchPart[T any](part *multipart.Part) batchResult[T] {
+	var result batchResult[T]
+
+	resp, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		result.err = fmt.Errorf("failed to read batch part: %w", err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		result.err = err
+		return result
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result.value); err != nil {
+		result.err = fmt.Errorf("failed to decode batch part: %w", err)
+	}
+
+	return result
+}
+
+// parseBatchContentID extracts the sub-request index from a response
+// Content-ID such as "<response-item3>"
+func parseBatchContentID(contentID string) (int, error) {
+	id := strings.Trim(contentID, "<>")
+	id = strings.TrimPrefix(id, "response-")
+	return strconv.Atoi(strings.TrimPrefix(id, "item"))
+}
@@ -0,0 +1,260 @@
+package gmail
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestParseBatchContentID(t *testing.T) {
+	tests := []struct {
+		contentID string
+		want      int
+		wantErr   bool
+	}{
+		{"<response-item3>", 3, false},
+		{"response-item12", 12, false},
+		{"<item0>", 0, false},
+		{"<response-other>", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentID, func(t *testing.T) {
+			got, err := parseBatchContentID(tt.contentID)
+			if (err != nil) != tt.wantErr || (err == nil && got != tt.want) {
+				t.Errorf("parseBatchContentID(%q) = %d, %v, want %d, error %t", tt.contentID, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// batchServer serves the Gmail batch endpoint and single message gets. The
+// batch sub-responses are given by message ID as a status and a Content-ID,
+// messages without a sub-response are left out of the batch response.
+type batchServer struct {
+	batch   map[string]batchSubResponse
+	single  map[string]int
+	mu      sync.Mutex
+	gets    []string
+	batches [][]string
+}
+
+type batchSubResponse struct {
+	status    int
+	contentID string
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/batch/gmail/v1" {
+		s.serveBatch(w, r)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+	s.mu.Lock()
+	s.gets = append(s.gets, id)
+	s.mu.Unlock()
+
+	status, ok := s.single[id]
+	if !ok {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		fmt.Fprintf(w, `{"id":%q}`, id)
+	} else {
+		fmt.Fprintf(w, `{"error":{"code":%d,"message":"failed"}}`, status)
+	}
+}
+
+func (s *batchServer) serveBatch(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ids []string
+	var contentIDs []string
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.Header.Get("Content-Type") != "application/http" {
+			http.Error(w, "unexpected part type", http.StatusBadRequest)
+			return
+		}
+
+		// Sub-requests are written as a request line without HTTP version,
+		// which the batch endpoint accepts
+		line, err := bufio.NewReader(part).ReadString('\n')
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		method, target, _ := strings.Cut(strings.TrimSpace(line), " ")
+		path, query, _ := strings.Cut(target, "?")
+		if method != http.MethodGet || query != "format=full" || !strings.HasPrefix(path, "/gmail/v1/users/me/messages/") {
+			http.Error(w, "unexpected sub-request "+line, http.StatusBadRequest)
+			return
+		}
+		id := strings.TrimPrefix(path, "/gmail/v1/users/me/messages/")
+		ids = append(ids, id)
+		contentIDs = append(contentIDs, part.Header.Get("Content-ID"))
+	}
+
+	s.mu.Lock()
+	s.batches = append(s.batches, ids)
+	s.mu.Unlock()
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	for i, id := range ids {
+		sub, ok := s.batch[id]
+		if !ok {
+			continue
+		}
+		contentID := sub.contentID
+		if contentID == "" {
+			contentID = "<response-" + strings.Trim(contentIDs[i], "<>") + ">"
+		}
+
+		part, _ := mw.CreatePart(map[string][]string{"Content-Type": {"application/http"}, "Content-ID": {contentID}})
+		body := fmt.Sprintf(`{"id":%q}`, id)
+		if sub.status != http.StatusOK {
+			body = fmt.Sprintf(`{"error":{"code":%d,"message":"failed"}}`, sub.status)
+		}
+		fmt.Fprintf(part, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
+			sub.status, http.StatusText(sub.status), len(body), body)
+	}
+	mw.Close()
+}
+
+// newTestClient creates a client sending its requests to server
+func newTestClient(t *testing.T, server *httptest.Server, options ClientOptions) *Client {
+	t.Helper()
+	client, err := NewClient(context.Background(), server.Client(), options)
+	if err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+	client.service.BasePath = server.URL + "/"
+	return client
+}
+
+func TestBatchGet(t *testing.T) {
+	handler := &batchServer{batch: map[string]batchSubResponse{
+		"ok":          {status: http.StatusOK},
+		"missing":     {status: http.StatusNotFound},
+		"limited":     {status: http.StatusTooManyRequests},
+		"unknown":     {status: http.StatusOK, contentID: "<response-item99>"},
+		"not-numeric": {status: http.StatusOK, contentID: "<response-other>"},
+	}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := newTestClient(t, server, ClientOptions{})
+
+	ids := []string{"ok", "missing", "limited", "unknown", "not-numeric", "absent"}
+	paths := make([]string, len(ids))
+	for i, id := range ids {
+		paths[i] = "messages/" + id + "?format=full"
+	}
+
+	results, err := batchGet[*struct{ ID string }](context.Background(), client, paths)
+	if err != nil {
+		t.Fatalf("batchGet returned error: %v", err)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("got %d results, want %d", len(results), len(ids))
+	}
+	if got := handler.batches; len(got) != 1 || !slices.Equal(got[0], ids) {
+		t.Errorf("batch sub-requests = %q, want %q", got, ids)
+	}
+
+	tests := []struct {
+		id         string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"ok", 0, false},
+		{"missing", http.StatusNotFound, true},
+		{"limited", http.StatusTooManyRequests, true},
+		{"unknown", 0, true},
+		{"not-numeric", 0, true},
+		{"absent", 0, true},
+	}
+	for i, tt := range tests {
+		result := results[i]
+		if (result.err != nil) != tt.wantErr {
+			t.Errorf("result of %s error = %v, want error %t", tt.id, result.err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (result.value == nil || result.value.ID != tt.id) {
+			t.Errorf("result of %s = %+v", tt.id, result.value)
+		}
+
+		var apiErr *googleapi.Error
+		if tt.wantStatus != 0 && (!errors.As(result.err, &apiErr) || apiErr.Code != tt.wantStatus) {
+			t.Errorf("result of %s error = %v, want status %d", tt.id, result.err, tt.wantStatus)
+		}
+	}
+}
+
+func TestBatchGetTooLarge(t *testing.T) {
+	client := &Client{}
+	if _, err := batchGet[any](context.Background(), client, make([]string, MaxBatchSize+1)); err == nil {
+		t.Error("batchGet accepted more than MaxBatchSize requests")
+	}
+}
+
+func TestFetchMessagesFallsBackToSingleRequests(t *testing.T) {
+	handler := &batchServer{
+		batch: map[string]batchSubResponse{
+			"ok":      {status: http.StatusOK},
+			"missing": {status: http.StatusNotFound},
+			"limited": {status: http.StatusTooManyRequests},
+			"unknown": {status: http.StatusOK, contentID: "<response-item99>"},
+		},
+		single: map[string]int{"missing": http.StatusNotFound},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := newTestClient(t, server, ClientOptions{BatchSize: 10})
+
+	messages, err := client.fetchMessages(context.Background(), []string{"ok", "missing", "limited", "unknown", "absent"})
+	if err != nil {
+		t.Fatalf("fetchMessages returned error: %v", err)
+	}
+
+	var got []string
+	for _, msg := range messages {
+		got = append(got, msg.Id)
+	}
+	if want := []string{"ok", "limited", "unknown", "absent"}; !slices.Equal(got, want) {
+		t.Errorf("fetched messages %q, want %q", got, want)
+	}
+
+	slices.Sort(handler.gets)
+	if want := []string{"absent", "limited", "missing", "unknown"}; !slices.Equal(handler.gets, want) {
+		t.Errorf("single requests for %q, want %q", handler.gets, want)
+	}
+}
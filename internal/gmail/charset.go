@@ -0,0 +1,74 @@
+package gmail
+
+import (
+	"log/slog"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"google.golang.org/api/gmail/v1"
+)
+
+// metaCharsetLength is the number of leading bytes of an HTML part searched
+// for a <meta> charset declaration
+const metaCharsetLength = 4096
+
+// fallbackCharset decodes undeclared text that is not valid UTF-8, as the
+// most common legacy charset of mail clients
+const fallbackCharset = "windows-1252"
+
+// metaCharsetPattern matches both <meta charset="..."> and the charset
+// parameter of <meta http-equiv="Content-Type" content="...">
+var metaCharsetPattern = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_.:-]+)`)
+
+// decodeCharset converts the content of a text part to UTF-8. The charset is
+// taken from the Content-Type of the part, or from a <meta> declaration for
+// HTML; undeclared content is assumed to be UTF-8 when valid. It returns the
+// text along with the lowercased source charset.
+func decodeCharset(data []byte, part *gmail.MessagePart) (string, string) {
+	label := ""
+	if _, params, err := mime.ParseMediaType(partHeader(part, "Content-Type")); err == nil {
+		label = params["charset"]
+	}
+	if label == "" && part.MimeType == "text/html" {
+		label = htmlMetaCharset(data)
+	}
+
+	label = strings.ToLower(strings.Trim(strings.TrimSpace(label), `"'`))
+	if label == "" {
+		if utf8.Valid(data) {
+			return string(data), "utf-8"
+		}
+		label = fallbackCharset
+	}
+
+	encoding, name := charset.Lookup(label)
+	if encoding == nil {
+		slog.Warn("Unknown charset, decoding as UTF-8", "charset", label, "part_id", part.PartId)
+		return strings.ToValidUTF8(string(data), "�"), label
+	}
+	if name == "utf-8" {
+		return strings.ToValidUTF8(string(data), "�"), label
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		slog.Warn("Failed to decode charset, decoding as UTF-8", "charset", label, "part_id", part.PartId, "error", err)
+		return strings.ToValidUTF8(string(data), "�"), label
+	}
+	return string(decoded), label
+}
+
+// htmlMetaCharset returns the charset declared by a <meta> tag at the start
+// of an HTML document
+func htmlMetaCharset(data []byte) string {
+	if len(data) > metaCharsetLength {
+		data = data[:metaCharsetLength]
+	}
+	if match := metaCharsetPattern.FindSubmatch(data); match != nil {
+		return string(match[1])
+	}
+	return ""
+}
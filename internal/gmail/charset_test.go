@@ -0,0 +1,41 @@
+package gmail
+
+import "testing"
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		mimeType    string
+		contentType string
+		data        string
+		want        string
+		wantCharset string
+	}{
+		{"declared latin-1", "text/plain", "text/plain; charset=iso-8859-1", "caf\xe9", "café", "iso-8859-1"},
+		{"declared quoted uppercase", "text/plain", `text/plain; charset="ISO-8859-15"`, "\xa4 5", "€ 5", "iso-8859-15"},
+		{"declared utf-8", "text/plain", "text/plain; charset=utf-8", "café", "café", "utf-8"},
+		{"declared utf-8 invalid", "text/plain", "text/plain; charset=utf-8", "caf\xe9", "caf�", "utf-8"},
+		{"declared shift_jis", "text/plain", "text/plain; charset=shift_jis", "\x93\xfa\x96\x7b", "日本", "shift_jis"},
+		{"meta charset", "text/html", "text/html", `<meta charset="iso-8859-1"><p>caf` + "\xe9", `<meta charset="iso-8859-1"><p>café`, "iso-8859-1"},
+		{"meta http-equiv", "text/html", "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">` + "\xcf\xf0\xe8", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">При`, "windows-1251"},
+		{"declared charset over meta", "text/html", "text/html; charset=utf-8", `<meta charset="iso-8859-1">café`, `<meta charset="iso-8859-1">café`, "utf-8"},
+		{"meta ignored for plain text", "text/plain", "text/plain", `<meta charset="iso-8859-1">café`, `<meta charset="iso-8859-1">café`, "utf-8"},
+		{"undeclared utf-8", "text/plain", "", "café", "café", "utf-8"},
+		{"undeclared fallback", "text/plain", "", "caf\xe9 \x93ok\x94", "café “ok”", "windows-1252"},
+		{"unknown charset", "text/plain", "text/plain; charset=x-unknown", "caf\xe9", "caf�", "x-unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part := testPart("0", tt.mimeType)
+			if tt.contentType != "" {
+				part = testPart("0", tt.mimeType, "Content-Type", tt.contentType)
+			}
+
+			got, charset := decodeCharset([]byte(tt.data), part)
+			if got != tt.want || charset != tt.wantCharset {
+				t.Errorf("decodeCharset(%q) = %q, %q, want %q, %q", tt.data, got, charset, tt.want, tt.wantCharset)
+			}
+		})
+	}
+}
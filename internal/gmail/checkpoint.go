@@ -0,0 +1,133 @@
+package gmail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint records the progress of an export so that an interrupted run
+// can be resumed where it stopped
+type Checkpoint struct {
+	Query       string    `json:"query"`
+	PageToken   string    `json:"page_token,omitempty"`
+	OutputSize  int64     `json:"output_size"`
+	ExportedIDs []string  `json:"exported_ids"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// HistoryID is the mailbox history ID captured when an incremental
+	// export started, so that a resumed run does not miss earlier changes
+	HistoryID uint64 `json:"history_id,omitempty"`
+
+	path     string
+	exported map[string]struct{}
+}
+
+// ErrNoCheckpoint is returned when resuming an export without a checkpoint,
+// in which case the output would be truncated to nothing
+var ErrNoCheckpoint = errors.New("no checkpoint to resume from")
+
+// LoadCheckpoint reads the checkpoint stored at path. It returns
+// ErrNoCheckpoint when the file is missing, and an error when the checkpoint
+// was created for another query.
+func LoadCheckpoint(path, query string) (*Checkpoint, error) {
+	checkpoint := NewCheckpoint(path, query)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrNoCheckpoint, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if checkpoint.Query != query {
+		return nil, fmt.Errorf("checkpoint was created for query '%s', not '%s'", checkpoint.Query, query)
+	}
+
+	for _, id := range checkpoint.ExportedIDs {
+		checkpoint.exported[id] = struct{}{}
+	}
+
+	return checkpoint, nil
+}
+
+// NewCheckpoint creates an empty checkpoint stored at path for the given query
+func NewCheckpoint(path, query string) *Checkpoint {
+	return &Checkpoint{
+		Query:    query,
+		path:     path,
+		exported: make(map[string]struct{}),
+	}
+}
+
+// Exported reports whether the message has already been written to the output
+func (c *Checkpoint) Exported(id string) bool {
+	_, ok := c.exported[id]
+	return ok
+}
+
+// MarkExported records a message as written to the output
+func (c *Checkpoint) MarkExported(id string) {
+	if c.Exported(id) {
+		return
+	}
+	c.exported[id] = struct{}{}
+	c.ExportedIDs = append(c.ExportedIDs, id)
+}
+
+// SetPageToken records the token of the next page to list
+func (c *Checkpoint) SetPageToken(pageToken string) {
+	c.PageToken = pageToken
+}
+
+// Save atomically writes the checkpoint to disk, along with the size of the
+// output file that its exported IDs correspond to
+func (c *Checkpoint) Save(outputSize int64) error {
+	c.OutputSize = outputSize
+	c.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file renamed over path, so that
+// a crash never leaves path partially written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Remove deletes the checkpoint file once the export has completed
+func (c *Checkpoint) Remove() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
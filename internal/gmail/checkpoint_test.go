@@ -0,0 +1,46 @@
+package gmail
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emails.jsonl.checkpoint")
+
+	if _, err := LoadCheckpoint(path, "label:inbox"); !errors.Is(err, ErrNoCheckpoint) {
+		t.Fatalf("LoadCheckpoint() of a missing file error = %v, want %v", err, ErrNoCheckpoint)
+	}
+
+	checkpoint := NewCheckpoint(path, "label:inbox")
+	checkpoint.MarkExported("a")
+	checkpoint.MarkExported("b")
+	checkpoint.MarkExported("a")
+	checkpoint.SetPageToken("next")
+	if err := checkpoint.Save(42); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+
+	loaded, err := LoadCheckpoint(path, "label:inbox")
+	if err != nil {
+		t.Fatalf("LoadCheckpoint returned error: %v", err)
+	}
+	if loaded.OutputSize != 42 || loaded.PageToken != "next" || len(loaded.ExportedIDs) != 2 {
+		t.Errorf("loaded checkpoint = size %d, page token %q, exported %q", loaded.OutputSize, loaded.PageToken, loaded.ExportedIDs)
+	}
+	if !loaded.Exported("a") || !loaded.Exported("b") || loaded.Exported("c") {
+		t.Errorf("loaded checkpoint exported IDs = %q, want a and b", loaded.ExportedIDs)
+	}
+
+	if _, err := LoadCheckpoint(path, "label:sent"); err == nil {
+		t.Error("LoadCheckpoint() for another query succeeded")
+	}
+
+	if err := loaded.Remove(); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if _, err := LoadCheckpoint(path, "label:inbox"); !errors.Is(err, ErrNoCheckpoint) {
+		t.Errorf("LoadCheckpoint() after Remove error = %v, want %v", err, ErrNoCheckpoint)
+	}
+}
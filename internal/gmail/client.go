@@ -3,25 +3,82 @@ package gmail
 import (
 	"context"
 	"fmt"
+	"iter"
 	"log/slog"
+	"net/http"
+	"sync"
 
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
 )
 
+// DefaultConcurrency is the number of requests sent in parallel when no
+// concurrency is configured
+const DefaultConcurrency = 10
+
+// ClientOptions contains all options for configuring a Client
+type ClientOptions struct {
+	Concurrency int
+	// BatchSize is the number of messages fetched per batch request, zero
+	// disables batching and fetches messages individually
+	BatchSize int
+	// MaxRetries is the number of times a failed request is retried
+	MaxRetries int
+	// QuotaRate limits requests to the given number of Gmail quota units
+	// per second, zero disables rate limiting
+	QuotaRate float64
+	// IncludeRaw also fetches the raw RFC 822 source of every message
+	IncludeRaw bool
+}
+
 type Client struct {
-	service *gmail.Service
+	service     *gmail.Service
+	httpClient  *http.Client
+	concurrency int
+	batchSize   int
+	maxRetries  int
+	limiter     *rateLimiter
+	includeRaw  bool
 }
 
-func NewClient(service *gmail.Service) *Client {
-	return &Client{
-		service: service,
+// NewClient creates a Client using an authenticated HTTP client, which is
+// shared between the Gmail service and batch requests
+func NewClient(ctx context.Context, httpClient *http.Client, options ClientOptions) (*Client, error) {
+	service, err := gmail.NewService(ctx, option.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gmail service: %w", err)
+	}
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
 	}
+
+	batchSize := options.BatchSize
+	if batchSize < 0 {
+		batchSize = 0
+	}
+	if batchSize > MaxBatchSize {
+		batchSize = MaxBatchSize
+	}
+
+	return &Client{
+		service:     service,
+		httpClient:  httpClient,
+		concurrency: concurrency,
+		batchSize:   batchSize,
+		maxRetries:  max(options.MaxRetries, 0),
+		limiter:     newRateLimiter(options.QuotaRate),
+		includeRaw:  options.IncludeRaw,
+	}, nil
 }
 
 func (c *Client) GetLabelID(ctx context.Context, labelName string) (string, error) {
 	user := "me"
 	labelsCall := c.service.Users.Labels.List(user)
-	labels, err := labelsCall.Context(ctx).Do()
+	labels, err := withRetry(ctx, c, quotaLabelsList, func() (*gmail.ListLabelsResponse, error) {
+		return labelsCall.Context(ctx).Do()
+	})
 	if err != nil {
 		return "", fmt.Errorf("unable to retrieve labels: %v", err)
 	}
@@ -35,56 +92,340 @@ func (c *Client) GetLabelID(ctx context.Context, labelName string) (string, erro
 	return "", fmt.Errorf("label '%s' not found", labelName)
 }
 
-// GetMessagesByQuery fetches messages with full details using batch requests
-func (c *Client) GetMessagesByQuery(ctx context.Context, query string, limit int64) ([]*gmail.Message, error) {
+// GetLabelNames returns the names of all labels indexed by label ID
+func (c *Client) GetLabelNames(ctx context.Context) (map[string]string, error) {
 	user := "me"
+	labelsCall := c.service.Users.Labels.List(user)
+	labels, err := withRetry(ctx, c, quotaLabelsList, func() (*gmail.ListLabelsResponse, error) {
+		return labelsCall.Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve labels: %v", err)
+	}
+
+	names := make(map[string]string, len(labels.Labels))
+	for _, label := range labels.Labels {
+		names[label.Id] = label.Name
+	}
+
+	return names, nil
+}
+
+// QueryOptions contains all options for listing messages matching a query
+type QueryOptions struct {
+	Limit int64
+	// PageToken resumes listing from a page returned by a previous run
+	PageToken string
+	// Skip reports whether a listed message should be left out without
+	// being fetched or counted towards Limit
+	Skip func(id string) bool
+	// OnPage is called with the token of the next page once every message
+	// of the current page has been yielded, or with an empty token after
+	// the last page
+	OnPage func(nextPageToken string)
+}
+
+// GetMessagesByQuery fetches messages with full details using batch requests,
+// retrieving each page of results with a bounded pool of concurrent workers
+func (c *Client) GetMessagesByQuery(ctx context.Context, query string, limit int64) ([]*gmail.Message, error) {
 	var allMessages []*gmail.Message
-	var pageToken string
-
-	// Gmail API max is 500 per page
-	const maxPageSize int64 = 500
-	for {
-		remaining := limit - int64(len(allMessages))
-		if remaining <= 0 {
-			break
+	for msg, err := range c.StreamMessagesByQuery(ctx, query, QueryOptions{Limit: limit}) {
+		if err != nil {
+			return nil, err
 		}
+		allMessages = append(allMessages, msg)
+	}
 
-		pageSize := remaining
-		if pageSize > maxPageSize {
-			pageSize = maxPageSize
-		}
+	return allMessages, nil
+}
 
+// StreamMessagesByQuery yields messages with full details as soon as each
+// page of results has been fetched, so that only one page is held in memory
+// at a time. Iteration stops after the first error.
+func (c *Client) StreamMessagesByQuery(ctx context.Context, query string, options QueryOptions) iter.Seq2[*gmail.Message, error] {
+	user := "me"
+	list := func(pageToken string, pageSize int64) ([]string, string, error) {
 		call := c.service.Users.Messages.List(user).Q(query).MaxResults(pageSize)
 		if pageToken != "" {
 			call = call.PageToken(pageToken)
 		}
 
-		response, err := call.Context(ctx).Do()
+		response, err := withRetry(ctx, c, quotaMessagesList, func() (*gmail.ListMessagesResponse, error) {
+			return call.Context(ctx).Do()
+		})
 		if err != nil {
-			return nil, fmt.Errorf("unable to retrieve messages: %v", err)
+			return nil, "", fmt.Errorf("unable to retrieve messages: %v", err)
 		}
 
-		// Fetch full message details for all messages in this page
+		ids := make([]string, 0, len(response.Messages))
 		for _, msg := range response.Messages {
-			fullMsg, err := c.service.Users.Messages.Get(user, msg.Id).Format("full").Context(ctx).Do()
+			ids = append(ids, msg.Id)
+		}
+		return ids, response.NextPageToken, nil
+	}
+
+	return streamByQuery(ctx, "messages", list, c.fetchMessages, options)
+}
+
+// streamByQuery pages through the IDs returned by list and yields the
+// corresponding resources, fetching the details of each page with fetch
+func streamByQuery[T any](ctx context.Context, kind string, list func(pageToken string, pageSize int64) ([]string, string, error), fetch func(ctx context.Context, ids []string) ([]T, error), options QueryOptions) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		limit := options.Limit
+		var fetched int64
+		pageToken := options.PageToken
+
+		// Gmail API max is 500 per page
+		const maxPageSize int64 = 500
+		for {
+			remaining := limit - fetched
+			if remaining <= 0 {
+				return
+			}
+
+			pageSize := remaining
+			if pageSize > maxPageSize {
+				pageSize = maxPageSize
+			}
+
+			listed, nextPageToken, err := list(pageToken, pageSize)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			// Fetch full details for all resources in this page
+			ids := make([]string, 0, len(listed))
+			for _, id := range listed {
+				if options.Skip != nil && options.Skip(id) {
+					continue
+				}
+				ids = append(ids, id)
+			}
+
+			values, err := fetch(ctx, ids)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			for _, value := range values {
+				if !yield(value, nil) {
+					return
+				}
+
+				fetched++
+				if fetched >= limit {
+					return
+				}
+			}
+
+			pageToken = nextPageToken
+			if options.OnPage != nil {
+				options.OnPage(pageToken)
+			}
+			if pageToken == "" {
+				return
+			}
+
+			slog.Info("Fetching "+kind, "fetched_count", fetched, "limit", limit)
+		}
+	}
+}
+
+// StreamMessagesByID yields the full details of the given messages, fetching
+// them one page at a time. Iteration stops after the first error.
+func (c *Client) StreamMessagesByID(ctx context.Context, ids []string) iter.Seq2[*gmail.Message, error] {
+	return func(yield func(*gmail.Message, error) bool) {
+		const pageSize = 500
+		for start := 0; start < len(ids); start += pageSize {
+			messages, err := c.fetchMessages(ctx, ids[start:min(start+pageSize, len(ids))])
 			if err != nil {
-				slog.Warn("Error retrieving message", "message_id", msg.Id, "error", err)
-				continue
+				yield(nil, err)
+				return
+			}
+
+			for _, msg := range messages {
+				if !yield(msg, nil) {
+					return
+				}
 			}
-			allMessages = append(allMessages, fullMsg)
+		}
+	}
+}
+
+// resource describes how to retrieve one kind of Gmail resource by ID, either
+// individually or as a sub-request of a batch request
+type resource[T any] struct {
+	kind string
+	cost int
+	// path returns the sub-request path of an ID, relative to the user
+	path func(id string) string
+	get  func(ctx context.Context, id string) (T, error)
+}
+
+// messageResource describes how to retrieve messages in the given format
+func (c *Client) messageResource(format string) resource[*gmail.Message] {
+	user := "me"
+	return resource[*gmail.Message]{
+		kind: "message",
+		cost: quotaMessagesGet,
+		path: func(id string) string {
+			return "messages/" + id + "?format=" + format
+		},
+		get: func(ctx context.Context, id string) (*gmail.Message, error) {
+			return c.service.Users.Messages.Get(user, id).Format(format).Context(ctx).Do()
+		},
+	}
+}
+
+// fetchMessages retrieves the full details of the given messages, along with
+// their raw RFC 822 source when raw messages are requested
+func (c *Client) fetchMessages(ctx context.Context, ids []string) ([]*gmail.Message, error) {
+	messages, err := fetchAll(ctx, c, c.messageResource("full"), ids)
+	if err != nil || !c.includeRaw {
+		return messages, err
+	}
+
+	if err := c.attachRaw(ctx, messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// attachRaw fetches the raw format of the given messages and stores it in
+// their Raw field. Messages whose raw format cannot be retrieved are logged
+// and left without it.
+func (c *Client) attachRaw(ctx context.Context, messages []*gmail.Message) error {
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.Id)
+	}
+
+	raws, err := fetchAll(ctx, c, c.messageResource("raw"), ids)
+	if err != nil {
+		return err
+	}
+
+	rawByID := make(map[string]string, len(raws))
+	for _, raw := range raws {
+		rawByID[raw.Id] = raw.Raw
+	}
+	for _, msg := range messages {
+		msg.Raw = rawByID[msg.Id]
+	}
+
+	return nil
+}
 
-			if int64(len(allMessages)) >= limit {
-				return allMessages[:limit], nil
+// fetchAll retrieves the given resources in parallel, grouping them into
+// batch requests when batching is enabled. The returned resources keep the
+// order of ids; resources that cannot be retrieved are logged and left out.
+func fetchAll[T any](ctx context.Context, c *Client, res resource[T], ids []string) ([]T, error) {
+	results := make([]T, len(ids))
+	found := make([]bool, len(ids))
+
+	get := func(i int) {
+		result, err := withRetry(ctx, c, res.cost, func() (T, error) {
+			return res.get(ctx, ids[i])
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Error retrieving "+res.kind, res.kind+"_id", ids[i], "error", err)
 			}
+			return
 		}
+		results[i] = result
+		found[i] = true
+	}
+
+	if c.batchSize > 0 {
+		var failed []int
+		var mu sync.Mutex
+
+		batches := (len(ids) + c.batchSize - 1) / c.batchSize
+		c.forEach(ctx, batches, func(b int) {
+			start := b * c.batchSize
+			end := min(start+c.batchSize, len(ids))
+
+			paths := make([]string, 0, end-start)
+			for _, id := range ids[start:end] {
+				paths = append(paths, res.path(id))
+			}
+
+			batch, err := withRetry(ctx, c, res.cost*(end-start), func() ([]batchResult[T], error) {
+				return batchGet[T](ctx, c, paths)
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("Batch request failed, falling back to single requests", "size", end-start, "error", err)
+				}
+				mu.Lock()
+				for i := start; i < end; i++ {
+					failed = append(failed, i)
+				}
+				mu.Unlock()
+				return
+			}
 
-		pageToken = response.NextPageToken
-		if pageToken == "" {
-			break
+			for j, result := range batch {
+				if result.err != nil {
+					slog.Debug("Batch sub-request failed, retrying with single request", res.kind+"_id", ids[start+j], "error", result.err)
+					mu.Lock()
+					failed = append(failed, start+j)
+					mu.Unlock()
+					continue
+				}
+				results[start+j] = result.value
+				found[start+j] = true
+			}
+		})
+
+		c.forEach(ctx, len(failed), func(i int) {
+			get(failed[i])
+		})
+	} else {
+		c.forEach(ctx, len(ids), get)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	values := make([]T, 0, len(results))
+	for i, result := range results {
+		if found[i] {
+			values = append(values, result)
 		}
+	}
 
-		slog.Info("Fetching messages", "fetched_count", len(allMessages), "limit", limit)
+	return values, nil
+}
+
+// forEach calls fn for every index in [0, n) using at most c.concurrency
+// goroutines, and stops handing out work once ctx is cancelled
+func (c *Client) forEach(ctx context.Context, n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(c.concurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
 	}
 
-	return allMessages, nil
+feed:
+	for i := range n {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
 }
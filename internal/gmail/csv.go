@@ -0,0 +1,156 @@
+package gmail
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultCSVSeparator joins the values of multi-valued CSV columns
+const DefaultCSVSeparator = "; "
+
+// DefaultCSVColumns are the columns written when none are configured. Body
+// columns are left out and must be requested explicitly.
+var DefaultCSVColumns = []string{
+	"id", "thread_id", "date", "from", "to", "cc", "subject", "labels",
+	"attachment_count", "attachment_names", "snippet",
+}
+
+// csvColumns maps the supported CSV column names to the value they hold
+var csvColumns = map[string]func(w *csvWriter, msg *gmail.Message, record *JSONLEmail) string{
+	"id":        func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.ID },
+	"thread_id": func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.ThreadID },
+	"date":      func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.Date },
+	"from":      func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.From },
+	"to":        func(w *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return w.join(r.To) },
+	"cc":        func(w *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return w.join(r.Cc) },
+	"bcc":       func(w *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return w.join(r.Bcc) },
+	"subject":   func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.Subject },
+	"labels": func(w *csvWriter, _ *gmail.Message, r *JSONLEmail) string {
+		labels := make([]string, 0, len(r.LabelIDs))
+		for _, labelID := range r.LabelIDs {
+			if name, ok := w.labelNames[labelID]; ok {
+				labelID = name
+			}
+			labels = append(labels, labelID)
+		}
+		return w.join(labels)
+	},
+	"attachment_count": func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string {
+		return strconv.Itoa(len(r.Attachments))
+	},
+	"attachment_names": func(w *csvWriter, _ *gmail.Message, r *JSONLEmail) string {
+		names := make([]string, 0, len(r.Attachments))
+		for _, att := range r.Attachments {
+			names = append(names, att.Filename)
+		}
+		return w.join(names)
+	},
+	// Gmail returns snippets with HTML entities escaped
+	"snippet":       func(_ *csvWriter, msg *gmail.Message, _ *JSONLEmail) string { return html.UnescapeString(msg.Snippet) },
+	"date_source":   func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.DateSource },
+	"body_text":     func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.Body.Text },
+	"body_markdown": func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.Body.Markdown },
+	"body_html":     func(_ *csvWriter, _ *gmail.Message, r *JSONLEmail) string { return r.Body.HTML },
+}
+
+// csvWriter writes one CSV row per email, preceded by a header row when the
+// output file is empty
+type csvWriter struct {
+	out        *streamOutput
+	csv        *csv.Writer
+	columns    []string
+	separator  string
+	labelNames map[string]string
+}
+
+func newCSVWriter(options ExportOptions) (*csvWriter, error) {
+	names := options.CSVColumns
+	if len(names) == 0 {
+		names = DefaultCSVColumns
+	}
+	columns := make([]string, 0, len(names))
+	for _, column := range names {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := csvColumns[column]; !ok {
+			return nil, fmt.Errorf("unsupported csv column '%s'", column)
+		}
+		columns = append(columns, column)
+	}
+
+	separator := options.CSVSeparator
+	if separator == "" {
+		separator = DefaultCSVSeparator
+	}
+
+	out, err := newStreamOutput(options)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &csvWriter{
+		out:        out,
+		csv:        csv.NewWriter(out),
+		columns:    columns,
+		separator:  separator,
+		labelNames: options.LabelNames,
+	}
+
+	// Appended and resumed files already start with the header row
+	if out.counter.n == 0 {
+		if err := w.csv.Write(columns); err != nil {
+			out.Close()
+			return nil, fmt.Errorf("failed to write csv header: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+func (w *csvWriter) Write(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+
+	row := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		row[i] = escapeFormula(csvColumns[column](w, msg, &record))
+	}
+
+	if err := w.csv.Write(row); err != nil {
+		return fmt.Errorf("failed to write csv row: %w", err)
+	}
+	return nil
+}
+
+func (w *csvWriter) Flush() (int64, error) {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return 0, fmt.Errorf("failed to write csv: %w", err)
+	}
+	return w.out.Flush()
+}
+
+func (w *csvWriter) Close() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		w.out.Close()
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return w.out.Close()
+}
+
+func (w *csvWriter) join(values []string) string {
+	return strings.Join(values, w.separator)
+}
+
+// escapeFormula prefixes values that spreadsheets would evaluate as formulas
+// with a quote, since subjects and addresses come from untrusted senders
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
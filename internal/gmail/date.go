@@ -0,0 +1,179 @@
+package gmail
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of the date of an email
+const (
+	// DateSourceHeader is a date read from the Date header
+	DateSourceHeader = "header"
+	// DateSourceInternal is the date Gmail received the email, used when the
+	// Date header is missing or cannot be parsed
+	DateSourceInternal = "internal_date"
+)
+
+// dateLayouts are tried in order on a normalized Date header, after
+// mail.ParseDate failed. The day of the week and the zone are removed
+// beforehand, see normalizeDate.
+var dateLayouts = []string{
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 06 15:04:05",
+	"2 Jan 06 15:04",
+	"Jan 2 2006 15:04:05",
+	"Jan 2 2006 15:04",
+	"Jan 2 15:04:05 2006",
+	"2-Jan-2006 15:04:05",
+	"2-Jan-2006 15:04",
+	"2-Jan-06 15:04:05",
+	"2 January 2006 15:04:05",
+	"2 January 2006 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+}
+
+// dateZones maps the zone names found in Date headers to their offset in
+// hours. The North American names come from RFC 822, the others are common
+// in malformed headers.
+var dateZones = map[string]int{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0,
+	"EST": -5, "EDT": -4, "CST": -6, "CDT": -5,
+	"MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+	"WET": 0, "WEST": 1, "BST": 1, "CET": 1, "CEST": 2, "MET": 1, "MEST": 2,
+	"EET": 2, "EEST": 3, "MSK": 3, "JST": 9, "KST": 9, "HKT": 8,
+	"AEST": 10, "AEDT": 11, "NZST": 12, "NZDT": 13,
+}
+
+var (
+	// dateCommentPattern matches parenthesized comments, such as the
+	// "(GMT-5)" of "EST (GMT-5)"
+	dateCommentPattern = regexp.MustCompile(`\([^()]*\)`)
+	// dateOffsetPattern matches a trailing numeric zone, with or without a
+	// GMT or UTC prefix and a colon: +0200, -05:00, GMT+2, UTC-0530
+	dateOffsetPattern = regexp.MustCompile(`(?i)\s*(?:(?:GMT|UTC|UT)\s*)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+	// dateGMTOffsetPattern matches the zone names of GMT or UTC offsets
+	dateGMTOffsetPattern = regexp.MustCompile(`(?i)^(?:GMT|UTC|UT)[+-]`)
+	// dateWeekdayPattern matches a leading day of the week, abbreviated or
+	// not
+	dateWeekdayPattern = regexp.MustCompile(`(?i)^(?:mon|tue|wed|thu|fri|sat|sun)[a-z]*\.?,?\s*`)
+)
+
+// parseDate parses a Date header, accepting the malformed dates produced by
+// some mailers: missing seconds, two-digit years, named zones, zones in a
+// comment or extra whitespace. Dates without a zone are read as UTC.
+func parseDate(value string) (time.Time, error) {
+	if date, err := mail.ParseDate(value); err == nil {
+		name, offset := date.Zone()
+		switch {
+		case offset == 0 && dateZones[name] != 0:
+			// Zone names unknown to the local time zone database are parsed
+			// with a zero offset
+			date = time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), 0, time.FixedZone(name, dateZones[name]*3600))
+		case dateGMTOffsetPattern.MatchString(name):
+			// Zones such as GMT-5 are parsed with the wall clock read as UTC,
+			// and the offset then applied
+			utc := date.UTC()
+			date = time.Date(utc.Year(), utc.Month(), utc.Day(), utc.Hour(), utc.Minute(), utc.Second(), 0, time.FixedZone(name, offset))
+		}
+		return date, nil
+	}
+
+	text, location, comments := normalizeDate(value)
+	for _, comment := range comments {
+		// Zones only given in a comment, as in "10:00 (GMT-5)"
+		if location == nil {
+			location = parseZone(comment)
+		}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	for _, layout := range dateLayouts {
+		if date, err := time.ParseInLocation(layout, text, location); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date format %q", value)
+}
+
+// normalizeDate removes the comments, day of the week and zone of a date. It
+// returns the remaining text with single spaces, the zone when there is
+// one, and the content of the comments.
+func normalizeDate(value string) (string, *time.Location, []string) {
+	var comments []string
+	text := dateCommentPattern.ReplaceAllStringFunc(value, func(comment string) string {
+		comments = append(comments, strings.Trim(comment, "()"))
+		return " "
+	})
+	text = strings.Join(strings.Fields(text), " ")
+	text = dateWeekdayPattern.ReplaceAllString(text, "")
+
+	var location *time.Location
+	// A time must precede a numeric zone, so that the day of 2006-01-02 is
+	// not mistaken for one
+	if match := dateOffsetPattern.FindStringSubmatch(text); match != nil && strings.Contains(text[:len(text)-len(match[0])], ":") {
+		location = offsetZone(match)
+		text = text[:len(text)-len(match[0])]
+	} else if i := strings.LastIndex(text, " "); i >= 0 {
+		if location = parseZone(text[i+1:]); location != nil {
+			text = text[:i]
+		}
+	}
+
+	return strings.TrimSpace(text), location, comments
+}
+
+// parseZone parses a zone name or a numeric zone such as GMT-5, returning
+// nil when the text is not a zone
+func parseZone(text string) *time.Location {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	if hours, ok := dateZones[text]; ok {
+		return time.FixedZone(text, hours*3600)
+	}
+	if match := dateOffsetPattern.FindStringSubmatch(text); match != nil && match[0] == text {
+		return offsetZone(match)
+	}
+	return nil
+}
+
+// offsetZone returns the zone matched by dateOffsetPattern
+func offsetZone(match []string) *time.Location {
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	offset := hours*3600 + minutes*60
+	if match[1] == "-" {
+		offset = -offset
+	}
+	return time.FixedZone("", offset)
+}
+
+// messageDate returns the date of a message from its Date header, falling
+// back to the date Gmail received it, along with the source of the date.
+// The source is empty when neither is available.
+func messageDate(value string, internalDate int64) (time.Time, string, error) {
+	var err error
+	if value != "" {
+		var date time.Time
+		if date, err = parseDate(value); err == nil {
+			return date, DateSourceHeader, nil
+		}
+	}
+
+	if internalDate != 0 {
+		return time.UnixMilli(internalDate).UTC(), DateSourceInternal, err
+	}
+	return time.Time{}, "", err
+}
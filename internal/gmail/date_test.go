@@ -0,0 +1,83 @@
+package gmail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"rfc 5322", "Wed, 1 Feb 2023 10:00:00 +0100", "2023-02-01T09:00:00Z"},
+		{"missing seconds", "Wed, 1 Feb 2023 10:00 +0100", "2023-02-01T09:00:00Z"},
+		{"named zone", "Wed, 1 Feb 2023 10:00:00 EST", "2023-02-01T15:00:00Z"},
+		{"named zone with comment", "Wed, 1 Feb 2023 10:00:00 EST (GMT-5)", "2023-02-01T15:00:00Z"},
+		{"named zone without seconds", "Wed, 1 Feb 2023 10:00 EST (GMT-5)", "2023-02-01T15:00:00Z"},
+		{"zone unknown to the time database", "1 Feb 2023 10:00:00 CEST", "2023-02-01T08:00:00Z"},
+		{"two-digit year", "1 Feb 23 10:00:00 +0000", "2023-02-01T10:00:00Z"},
+		{"two-digit year without seconds", "Wed, 1 Feb 23 10:00 GMT", "2023-02-01T10:00:00Z"},
+		{"gmt offset", "Wed, 1 Feb 2023 10:00:00 GMT-5", "2023-02-01T15:00:00Z"},
+		{"gmt positive offset", "Wed, 1 Feb 2023 10:00:00 GMT+2", "2023-02-01T08:00:00Z"},
+		{"utc offset with minutes", "Wed, 1 Feb 2023 10:00:00 UTC+0530", "2023-02-01T04:30:00Z"},
+		{"offset with colon", "Wednesday, 01-Feb-2023 10:00:00 -05:00", "2023-02-01T15:00:00Z"},
+		{"zone only in comment", "Wed, 1 Feb 2023 10:00 (GMT-05:00)", "2023-02-01T15:00:00Z"},
+		{"extra whitespace", "Wed,  1   Feb 2023  10:00:00   +0000", "2023-02-01T10:00:00Z"},
+		{"month first", "Feb 1 2023 10:00 PDT", "2023-02-01T17:00:00Z"},
+		{"asctime", "Wed Feb 1 10:00:00 2023", "2023-02-01T10:00:00Z"},
+		{"iso without zone", "2023-02-01 10:00:00", "2023-02-01T10:00:00Z"},
+		{"iso with offset", "2023-02-01T10:00:00-0500", "2023-02-01T15:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDate(tt.value)
+			if err != nil {
+				t.Fatalf("parseDate(%q) returned error: %v", tt.value, err)
+			}
+			if got := got.UTC().Format(time.RFC3339); got != tt.want {
+				t.Errorf("parseDate(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateInvalid(t *testing.T) {
+	for _, value := range []string{"", "garbage", "Wed, 1 Feb 2023", "10.00.00 +0100"} {
+		if date, err := parseDate(value); err == nil {
+			t.Errorf("parseDate(%q) = %s, want an error", value, date)
+		}
+	}
+}
+
+func TestMessageDate(t *testing.T) {
+	internalDate := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		value        string
+		internalDate int64
+		want         time.Time
+		wantSource   string
+		wantErr      bool
+	}{
+		{"header", "Wed, 1 Feb 2023 10:00:00 +0000", internalDate.UnixMilli(), time.Date(2023, 2, 1, 10, 0, 0, 0, time.UTC), DateSourceHeader, false},
+		{"invalid header", "garbage", internalDate.UnixMilli(), internalDate, DateSourceInternal, true},
+		{"missing header", "", internalDate.UnixMilli(), internalDate, DateSourceInternal, false},
+		{"nothing", "", 0, time.Time{}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, source, err := messageDate(tt.value, tt.internalDate)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("messageDate(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) || source != tt.wantSource {
+				t.Errorf("messageDate(%q) = %s, %q, want %s, %q", tt.value, got, source, tt.want, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package gmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultESIndex is the index emails are written to when none is configured
+const DefaultESIndex = "gmail"
+
+// esRequestTimeout bounds each _bulk request sent in push mode
+const esRequestTimeout = 2 * time.Minute
+
+// esBatchSize is the number of items sent per _bulk request in push mode,
+// which bounds memory whatever the checkpoint schedule
+const esBatchSize = 100
+
+// esBulkItem is an action line of a _bulk request with its optional source
+// document, which delete actions do not have
+type esBulkItem struct {
+	id       string
+	action   []byte
+	document []byte
+}
+
+// esBulkResponse is the part of a _bulk response describing each item
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// errRetryableBulk marks _bulk requests that failed as a whole and may
+// succeed when sent again
+var errRetryableBulk = errors.New("bulk request failed")
+
+// esBulkWriter writes emails as Elasticsearch and OpenSearch _bulk
+// action/document pairs. Without a push URL the pairs are written to the
+// output file as NDJSON, otherwise they are sent to the URL in batches and
+// at every flush, and the items rejected with a transient error are retried.
+type esBulkWriter struct {
+	// ctx interrupts the retries of push mode when the export is canceled
+	ctx        context.Context
+	out        *streamOutput
+	index      string
+	url        string
+	client     *http.Client
+	maxRetries int
+	options    ExportOptions
+	pending    []esBulkItem
+}
+
+func newESBulkWriter(ctx context.Context, options ExportOptions) (*esBulkWriter, error) {
+	if options.IncludeRaw && options.RawDir != "" {
+		if err := os.MkdirAll(options.RawDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create raw messages directory: %w", err)
+		}
+	}
+
+	w := &esBulkWriter{
+		ctx:        ctx,
+		index:      options.ESIndex,
+		maxRetries: options.MaxRetries,
+		options:    options,
+	}
+	if w.index == "" {
+		w.index = DefaultESIndex
+	}
+
+	if options.ESURL == "" {
+		out, err := newStreamOutput(options)
+		if err != nil {
+			return nil, err
+		}
+		w.out = out
+		return w, nil
+	}
+
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported when pushing to a _bulk URL")
+	}
+	w.url = strings.TrimSuffix(options.ESURL, "/")
+	if !strings.HasSuffix(w.url, "/_bulk") {
+		w.url += "/_bulk"
+	}
+	w.client = &http.Client{Timeout: esRequestTimeout}
+
+	return w, nil
+}
+
+func (w *esBulkWriter) Write(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+	addOptionalFields(&record, msg, w.options)
+
+	return w.writeItem("index", msg.Id, record)
+}
+
+// WriteEvent deletes removed emails from the index and updates the labels of
+// the others
+func (w *esBulkWriter) WriteEvent(event JSONLEvent) error {
+	if event.Event == EventDeleted {
+		return w.writeItem("delete", event.ID, nil)
+	}
+
+	labelIDs := event.LabelIDs
+	if labelIDs == nil {
+		labelIDs = []string{}
+	}
+	return w.writeItem("update", event.ID, map[string]any{"doc": map[string]any{"label_ids": labelIDs}})
+}
+
+// writeItem writes an action on the document with the given ID, followed by
+// its source unless it is nil
+func (w *esBulkWriter) writeItem(op, id string, source any) error {
+	action, err := json.Marshal(map[string]any{op: map[string]string{"_index": w.index, "_id": id}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal bulk action: %w", err)
+	}
+	item := esBulkItem{id: id, action: append(action, '\n')}
+
+	if source != nil {
+		document, err := json.Marshal(source)
+		if err != nil {
+			slog.Warn("Failed to marshal record", "id", id, "error", err)
+			return nil
+		}
+		item.document = append(document, '\n')
+	}
+
+	if w.out == nil {
+		w.pending = append(w.pending, item)
+		if len(w.pending) >= esBatchSize {
+			return w.push()
+		}
+		return nil
+	}
+
+	if _, err := w.out.Write(item.action); err != nil {
+		return fmt.Errorf("failed to write bulk action: %w", err)
+	}
+	if _, err := w.out.Write(item.document); err != nil {
+		return fmt.Errorf("failed to write bulk document: %w", err)
+	}
+	return nil
+}
+
+// Flush writes buffered output, or sends the pending items when pushing,
+// in which case there is no output file size to return
+func (w *esBulkWriter) Flush() (int64, error) {
+	if w.out != nil {
+		return w.out.Flush()
+	}
+	return 0, w.push()
+}
+
+func (w *esBulkWriter) Close() error {
+	if w.out != nil {
+		return w.out.Close()
+	}
+	return w.push()
+}
+
+// push sends the pending items, retrying the whole request on transient
+// failures and the rejected items on 429 and server errors. Items rejected
+// permanently are reported once the others are indexed. The pending items
+// are dropped even on failure, so that closing the writer does not send
+// them again.
+func (w *esBulkWriter) push() error {
+	defer func() { w.pending = w.pending[:0] }()
+
+	items := w.pending
+	rejected := 0
+	for attempt := 0; len(items) > 0; attempt++ {
+		retry, failed, err := w.send(items)
+		rejected += failed
+		if err != nil && !errors.Is(err, errRetryableBulk) {
+			return err
+		}
+		if err != nil {
+			retry = items
+		}
+		if len(retry) == 0 {
+			break
+		}
+		if attempt >= w.maxRetries {
+			if err == nil {
+				err = fmt.Errorf("%d documents failed to index after %d retries", len(retry), attempt)
+			}
+			return errors.Join(err, rejectedError(rejected))
+		}
+
+		delay := retryDelay(nil, attempt)
+		slog.Debug("Retrying bulk request", "attempt", attempt+1, "items", len(retry), "delay", delay, "error", err)
+		if err := sleep(w.ctx, delay); err != nil {
+			return err
+		}
+		items = retry
+	}
+
+	return rejectedError(rejected)
+}
+
+// rejectedError reports the documents rejected permanently by the bulk API
+func rejectedError(rejected int) error {
+	if rejected == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d documents were rejected by the bulk API", rejected)
+}
+
+// send posts items in a single _bulk request and returns those rejected with
+// a transient error, along with the number of items rejected permanently,
+// which are logged. An error is only returned when the request failed as a
+// whole.
+func (w *esBulkWriter) send(items []esBulkItem) ([]esBulkItem, int, error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		body.Write(item.action)
+		body.Write(item.document)
+	}
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create bulk request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		if isTransientNetError(err) {
+			return nil, 0, fmt.Errorf("%w: %w", errRetryableBulk, err)
+		}
+		return nil, 0, fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: failed to read response: %w", errRetryableBulk, err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return nil, 0, fmt.Errorf("%w: status %d: %s", errRetryableBulk, resp.StatusCode, data)
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, 0, fmt.Errorf("bulk request failed with status %d: %s", resp.StatusCode, data)
+	}
+
+	var result esBulkResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, 0, nil
+	}
+	if len(result.Items) != len(items) {
+		return nil, 0, fmt.Errorf("bulk response has %d items, expected %d", len(result.Items), len(items))
+	}
+
+	var retry []esBulkItem
+	rejected := 0
+	for i, item := range result.Items {
+		for op, status := range item {
+			switch {
+			case status.Status < http.StatusMultipleChoices:
+			case (op == "delete" || op == "update") && status.Status == http.StatusNotFound:
+				// The email changed by an event was never indexed
+			case status.Status == http.StatusTooManyRequests || status.Status >= http.StatusInternalServerError:
+				retry = append(retry, items[i])
+			default:
+				rejected++
+				slog.Error("Document rejected by the bulk API", "id", items[i].id, "action", op, "status", status.Status, "error", string(status.Error))
+			}
+		}
+	}
+
+	return retry, rejected, nil
+}
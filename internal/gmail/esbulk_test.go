@@ -0,0 +1,130 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bulkResponse builds a _bulk response with one index item per status
+func bulkResponse(statuses ...int) string {
+	items := make([]string, len(statuses))
+	failed := false
+	for i, status := range statuses {
+		errorField := ""
+		if status >= 300 {
+			failed = true
+			errorField = `,"error":{"type":"test"}`
+		}
+		items[i] = fmt.Sprintf(`{"index":{"_id":"%d","status":%d%s}}`, i, status, errorField)
+	}
+	return fmt.Sprintf(`{"errors":%t,"items":[%s]}`, failed, strings.Join(items, ","))
+}
+
+func TestESBulkPushRetriesBeforeReportingRejections(t *testing.T) {
+	responses := []string{
+		bulkResponse(http.StatusOK, http.StatusBadRequest, http.StatusTooManyRequests),
+		bulkResponse(http.StatusCreated),
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests >= len(responses) {
+			t.Errorf("unexpected bulk request %d", requests+1)
+			http.Error(w, "unexpected", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, responses[requests])
+		requests++
+	}))
+	defer server.Close()
+
+	w, err := newESBulkWriter(context.Background(), ExportOptions{ESURL: server.URL, MaxRetries: 3})
+	if err != nil {
+		t.Fatalf("newESBulkWriter returned error: %v", err)
+	}
+	for _, id := range []string{"0", "1", "2"} {
+		if err := w.WriteEvent(JSONLEvent{Event: EventDeleted, ID: id}); err != nil {
+			t.Fatalf("WriteEvent returned error: %v", err)
+		}
+	}
+
+	if _, err := w.Flush(); err == nil || !strings.Contains(err.Error(), "1 documents were rejected") {
+		t.Errorf("Flush() error = %v, want the rejected document reported", err)
+	}
+	if requests != len(responses) {
+		t.Errorf("sent %d bulk requests, want %d", requests, len(responses))
+	}
+	if len(w.pending) != 0 {
+		t.Errorf("%d items still pending after the push", len(w.pending))
+	}
+}
+
+func TestESBulkPushCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, bulkResponse(http.StatusServiceUnavailable))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := newESBulkWriter(ctx, ExportOptions{ESURL: server.URL, MaxRetries: 3})
+	if err != nil {
+		t.Fatalf("newESBulkWriter returned error: %v", err)
+	}
+	if err := w.WriteEvent(JSONLEvent{Event: EventDeleted, ID: "0"}); err != nil {
+		t.Fatalf("WriteEvent returned error: %v", err)
+	}
+	cancel()
+
+	if err := w.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close() error = %v, want %v", err, context.Canceled)
+	}
+	if len(w.pending) != 0 {
+		t.Errorf("%d items still pending after the push", len(w.pending))
+	}
+}
+
+func TestESBulkPushBatches(t *testing.T) {
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read bulk request: %v", err)
+		}
+		// Delete actions have no source line
+		items := strings.Count(string(data), "\n")
+		batches = append(batches, items)
+
+		statuses := make([]int, items)
+		for i := range statuses {
+			statuses[i] = http.StatusOK
+		}
+		fmt.Fprint(w, bulkResponse(statuses...))
+	}))
+	defer server.Close()
+
+	w, err := newESBulkWriter(context.Background(), ExportOptions{ESURL: server.URL})
+	if err != nil {
+		t.Fatalf("newESBulkWriter returned error: %v", err)
+	}
+	for i := range 2*esBatchSize + 1 {
+		if err := w.WriteEvent(JSONLEvent{Event: EventDeleted, ID: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("WriteEvent returned error: %v", err)
+		}
+		if len(w.pending) >= esBatchSize {
+			t.Fatalf("%d items pending, want fewer than %d", len(w.pending), esBatchSize)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	if want := []int{esBatchSize, esBatchSize, 1}; !slices.Equal(batches, want) {
+		t.Errorf("bulk requests of %v items, want %v", batches, want)
+	}
+}
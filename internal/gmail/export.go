@@ -1,87 +1,373 @@
 package gmail
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
-	"net/mail"
 	"os"
 	"path/filepath"
 
 	"google.golang.org/api/gmail/v1"
 )
 
+// checkpointInterval is the number of exported emails between checkpoint saves
+const checkpointInterval = 100
+
+// Supported output formats
+const (
+	FormatJSONL       = "jsonl"
+	FormatMbox        = "mbox"
+	FormatMaildir     = "maildir"
+	FormatEML         = "eml"
+	FormatSQLite      = "sqlite"
+	FormatCSV         = "csv"
+	FormatParquet     = "parquet"
+	FormatMarkdownDir = "markdown-dir"
+	FormatHTMLSite    = "html-site"
+	FormatESBulk      = "es-bulk"
+)
+
 // ExportOptions contains all options for exporting emails
 type ExportOptions struct {
+	// Format is the output format, JSONL when empty
+	Format string
+	// Compression compresses formats written as a single stream
+	Compression        string
 	OutputFile         string
 	IncludeAttachments bool
 	AttachmentsDir     string
 	StripImages        bool
 	StripLinks         bool
+	// Checkpoint records exported messages so an interrupted export can be
+	// resumed, it is saved periodically and removed once the export completes
+	Checkpoint *Checkpoint
+	// Resume appends to the output file, truncated to the size recorded in
+	// Checkpoint, instead of replacing it
+	Resume bool
+	// Append writes after the existing content of the output file instead
+	// of replacing it
+	Append bool
+	// Events are change records written after the exported emails
+	Events []JSONLEvent
+	// IncludeRaw adds the RFC 822 source of each email to its record, the
+	// messages must have been fetched with ClientOptions.IncludeRaw
+	IncludeRaw bool
+	// IncludeParts adds the MIME structure of each email to its record
+	IncludeParts bool
+	// RawDir writes the RFC 822 source of each email to a sidecar .eml file
+	// in this directory instead of inlining it in the record
+	RawDir string
+	// LabelNames maps label IDs to names for formats that organize emails
+	// by label
+	LabelNames map[string]string
+	// CSVColumns are the columns written by the CSV format, DefaultCSVColumns
+	// when empty
+	CSVColumns []string
+	// CSVSeparator joins multi-valued CSV fields, DefaultCSVSeparator when
+	// empty
+	CSVSeparator string
+	// MarkdownGroup selects the folders of the markdown-dir format,
+	// MarkdownGroupThread when empty
+	MarkdownGroup string
+	// ESIndex is the index named in es-bulk actions, DefaultESIndex when
+	// empty
+	ESIndex string
+	// ESURL is the Elasticsearch or OpenSearch URL es-bulk actions are
+	// pushed to instead of being written to the output file
+	ESURL string
+	// MaxRetries is the number of times a failed request to ESURL is
+	// retried
+	MaxRetries int
 }
 
-// ExportToJSONL exports emails to JSONL format with all options using a context
-func ExportToJSONL(ctx context.Context, client *Client, messages []*gmail.Message, options ExportOptions) error {
-	outputDir := filepath.Dir(options.OutputFile)
-	if outputDir != "." && outputDir != "" {
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return fmt.Errorf("failed to create output directory: %w", err)
-		}
+// Writer writes exported emails in one output format
+type Writer interface {
+	// Write writes a single email, returning errSkipMessage when the email
+	// cannot be represented in the format
+	Write(msg *gmail.Message, email *Email) error
+	// Flush persists buffered output and returns the size of the output
+	// file to record in checkpoints, zero for formats that do not write a
+	// single file
+	Flush() (int64, error)
+	Close() error
+}
+
+// eventWriter is implemented by writers able to record History API events
+type eventWriter interface {
+	WriteEvent(event JSONLEvent) error
+}
+
+// errSkipMessage is returned by writers for emails they cannot export
+var errSkipMessage = errors.New("message skipped")
+
+// NewWriter creates the writer for the output format selected in options.
+// The context interrupts writers sending the output over the network.
+func NewWriter(ctx context.Context, options ExportOptions) (Writer, error) {
+	switch options.Format {
+	case FormatJSONL, "":
+		return newJSONLWriter(options)
+	case FormatMbox:
+		return newMboxWriter(options)
+	case FormatMaildir:
+		return newMaildirWriter(options)
+	case FormatEML:
+		return newEMLWriter(options)
+	case FormatSQLite:
+		return newSQLiteWriter(options)
+	case FormatCSV:
+		return newCSVWriter(options)
+	case FormatParquet:
+		return newParquetWriter(options)
+	case FormatMarkdownDir:
+		return newMarkdownDirWriter(options)
+	case FormatHTMLSite:
+		return newHTMLSiteWriter(options)
+	case FormatESBulk:
+		return newESBulkWriter(ctx, options)
+	default:
+		return nil, fmt.Errorf("unsupported format '%s'", options.Format)
 	}
+}
+
+// FormatRequiresRaw reports whether a format is built from the raw RFC 822
+// source of the emails, which must then be fetched with
+// ClientOptions.IncludeRaw
+func FormatRequiresRaw(format string) bool {
+	return format == FormatMbox || format == FormatMaildir || format == FormatEML
+}
 
-	file, err := os.Create(options.OutputFile)
+// Export exports emails in the output format selected in options using a
+// context. Messages are consumed from a stream and each email is written as
+// soon as its message arrives. It returns the number of exported emails.
+func Export(ctx context.Context, client *Client, messages iter.Seq2[*gmail.Message, error], options ExportOptions) (int, error) {
+	writer, err := NewWriter(ctx, options)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return 0, err
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	session := &exportSession{writer: writer, checkpoint: options.Checkpoint}
+	defer session.close()
 
-	// Store parsed emails if we need to download attachments later
-	var parsedEmails []*Email
-	if options.IncludeAttachments {
-		parsedEmails = make([]*Email, 0, len(messages))
+	if err := prepareAttachmentsDir(options); err != nil {
+		return 0, err
 	}
 
-	for i, msg := range messages {
-		if i%10 == 0 {
-			slog.Info("Processing emails", "progress", fmt.Sprintf("%d/%d", i+1, len(messages)))
+	exported := 0
+	for msg, err := range messages {
+		if err != nil {
+			session.abort()
+			return exported, err
+		}
+
+		if exported%10 == 0 {
+			slog.Info("Processing emails", "exported", exported)
 		}
 
-		// Messages already have full details from GetMessagesByQuery
+		// Messages already have full details from StreamMessagesByQuery
 		email, err := ParseMessage(msg, options.StripImages, options.StripLinks)
 		if err != nil {
 			slog.Warn("Failed to parse message", "id", msg.Id, "error", err)
 			continue
 		}
 
-		jsonlEmail := convertToJSONL(msg, email)
+		if err := writer.Write(msg, email); err != nil {
+			if errors.Is(err, errSkipMessage) {
+				slog.Warn("Failed to export message", "id", msg.Id, "error", err)
+				continue
+			}
+			return exported, err
+		}
+		exported++
+		session.markExported(msg.Id)
+
+		if options.IncludeAttachments && len(email.Attachments) > 0 {
+			downloadAttachments(ctx, client, email, options.AttachmentsDir)
+		}
+
+		if err := session.saveEvery(exported); err != nil {
+			return exported, err
+		}
+	}
+
+	if len(options.Events) > 0 {
+		events, ok := writer.(eventWriter)
+		if !ok {
+			slog.Warn("Output format cannot record deletions and label changes, skipping them", "format", options.Format, "events", len(options.Events))
+		}
+		for _, event := range options.Events {
+			if !ok {
+				break
+			}
+			if err := events.WriteEvent(event); err != nil {
+				return exported, err
+			}
+		}
+	}
+
+	if err := session.finish(); err != nil {
+		return exported, err
+	}
+	slog.Info("Export completed", "total", exported, "output", options.OutputFile)
 
-		data, err := json.Marshal(jsonlEmail)
+	return exported, nil
+}
+
+// ExportToJSONL exports emails to JSONL format with all options using a context.
+// Messages are consumed from a stream and each line is written as soon as its
+// message arrives. It returns the number of exported emails.
+func ExportToJSONL(ctx context.Context, client *Client, messages iter.Seq2[*gmail.Message, error], options ExportOptions) (int, error) {
+	options.Format = FormatJSONL
+	return Export(ctx, client, messages, options)
+}
+
+// ExportThreadsToJSONL exports conversations to JSONL format, writing one
+// record per thread with its messages in chronological order. It returns the
+// number of exported threads.
+func ExportThreadsToJSONL(ctx context.Context, client *Client, threads iter.Seq2[*gmail.Thread, error], options ExportOptions) (int, error) {
+	writer, err := newJSONLWriter(options)
+	if err != nil {
+		return 0, err
+	}
+
+	session := &exportSession{writer: writer, checkpoint: options.Checkpoint}
+	defer session.close()
+
+	if err := prepareAttachmentsDir(options); err != nil {
+		return 0, err
+	}
+
+	exported := 0
+	for thread, err := range threads {
 		if err != nil {
-			slog.Warn("Failed to marshal email", "id", msg.Id, "error", err)
-			continue
+			session.abort()
+			return exported, err
 		}
 
-		if _, err := writer.Write(data); err != nil {
-			return fmt.Errorf("failed to write JSON line: %w", err)
+		if exported%10 == 0 {
+			slog.Info("Processing threads", "exported", exported)
 		}
-		if _, err := writer.Write([]byte("\n")); err != nil {
-			return fmt.Errorf("failed to write newline: %w", err)
+
+		messages := make([]*gmail.Message, 0, len(thread.Messages))
+		emails := make([]*Email, 0, len(thread.Messages))
+		for _, msg := range thread.Messages {
+			email, err := ParseMessage(msg, options.StripImages, options.StripLinks)
+			if err != nil {
+				slog.Warn("Failed to parse message", "id", msg.Id, "thread_id", thread.Id, "error", err)
+				continue
+			}
+			messages = append(messages, msg)
+			emails = append(emails, email)
 		}
 
-		// Store parsed email for attachment download if needed
-		if options.IncludeAttachments && len(email.Attachments) > 0 {
-			parsedEmails = append(parsedEmails, email)
+		record := convertThreadToJSONL(thread.Id, messages, emails)
+		for i, msg := range messages {
+			addOptionalFields(&record.Messages[i], msg, options)
+		}
+
+		if err := writer.writeRecord(thread.Id, record); err != nil {
+			return exported, err
+		}
+		exported++
+		session.markExported(thread.Id)
+
+		if options.IncludeAttachments {
+			for _, email := range emails {
+				if len(email.Attachments) > 0 {
+					downloadAttachments(ctx, client, email, options.AttachmentsDir)
+				}
+			}
+		}
+
+		if err := session.saveEvery(exported); err != nil {
+			return exported, err
+		}
+	}
+
+	if err := session.finish(); err != nil {
+		return exported, err
+	}
+	slog.Info("Export completed", "threads", exported, "output", options.OutputFile)
+
+	return exported, nil
+}
+
+// exportSession keeps the export checkpoint in sync with what the writer
+// has persisted
+type exportSession struct {
+	writer     Writer
+	checkpoint *Checkpoint
+	closed     bool
+}
+
+// markExported records an exported email or thread in the checkpoint
+func (s *exportSession) markExported(id string) {
+	if s.checkpoint != nil {
+		s.checkpoint.MarkExported(id)
+	}
+}
+
+// saveEvery saves the checkpoint every checkpointInterval exported records
+func (s *exportSession) saveEvery(exported int) error {
+	if s.checkpoint == nil || exported%checkpointInterval != 0 {
+		return nil
+	}
+	return s.save()
+}
+
+// save flushes the writer so that the checkpoint never references records
+// missing from the output, then saves the checkpoint
+func (s *exportSession) save() error {
+	size, err := s.writer.Flush()
+	if err != nil {
+		return err
+	}
+	if s.checkpoint == nil {
+		return nil
+	}
+	return s.checkpoint.Save(size)
+}
+
+// abort saves progress after the stream failed so the export can be resumed
+func (s *exportSession) abort() {
+	if err := s.save(); err != nil {
+		slog.Warn("Failed to save checkpoint", "error", err)
+	}
+}
+
+// finish closes the writer and removes the checkpoint of a completed export
+func (s *exportSession) finish() error {
+	if err := s.save(); err != nil {
+		return err
+	}
+
+	s.closed = true
+	if err := s.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close output: %w", err)
+	}
+
+	if s.checkpoint != nil {
+		if err := s.checkpoint.Remove(); err != nil {
+			slog.Warn("Failed to remove checkpoint", "error", err)
 		}
 	}
-	slog.Info("Export completed", "total", len(messages), "output", options.OutputFile)
+	return nil
+}
+
+// close releases the writer of an export that did not finish
+func (s *exportSession) close() {
+	if !s.closed {
+		s.closed = true
+		_ = s.writer.Close()
+	}
+}
 
-	// Download attachments after all emails are exported
-	if !options.IncludeAttachments || len(parsedEmails) == 0 {
+// prepareAttachmentsDir creates the attachments directory when attachments
+// are downloaded
+func prepareAttachmentsDir(options ExportOptions) error {
+	if !options.IncludeAttachments {
 		return nil
 	}
 
@@ -89,31 +375,87 @@ func ExportToJSONL(ctx context.Context, client *Client, messages []*gmail.Messag
 	if err := os.MkdirAll(options.AttachmentsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create attachments directory: %w", err)
 	}
+	return nil
+}
 
-	for i, email := range parsedEmails {
-		if i%10 == 0 {
-			slog.Info("Downloading attachments", "progress", fmt.Sprintf("%d/%d", i+1, len(parsedEmails)))
-		}
+// rawMessage returns the decoded RFC 822 source of msg, or an error wrapping
+// errSkipMessage when it is not available
+func rawMessage(msg *gmail.Message) ([]byte, error) {
+	if msg.Raw == "" {
+		return nil, fmt.Errorf("%w: raw message is not available", errSkipMessage)
+	}
 
-		emailAttachDir := filepath.Join(options.AttachmentsDir, email.ID)
-		if err := os.MkdirAll(emailAttachDir, 0755); err != nil {
-			slog.Warn("Failed to create email attachment directory", "email_id", email.ID, "error", err)
-			continue
+	raw, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode raw message: %v", errSkipMessage, err)
+	}
+	return raw, nil
+}
+
+// addOptionalFields adds the fields selected in options to the record of msg
+func addOptionalFields(record *JSONLEmail, msg *gmail.Message, options ExportOptions) {
+	if options.IncludeRaw {
+		if err := setRaw(record, msg, options.RawDir); err != nil {
+			slog.Warn("Failed to export raw message", "id", msg.Id, "error", err)
 		}
+	}
+	if options.IncludeParts {
+		record.Parts = messageParts(msg.Payload)
+	}
+}
 
-		for _, att := range email.Attachments {
-			if err := client.DownloadAttachment(ctx, email.ID, att.ID, att.Filename, emailAttachDir); err != nil {
-				slog.Warn("Failed to download attachment", "filename", att.Filename, "message_id", email.ID, "error", err)
-				continue
-			}
+// setRaw stores the RFC 822 source of msg in record, inlined as standard
+// base64 or written to <rawDir>/<id>.eml when rawDir is set
+func setRaw(record *JSONLEmail, msg *gmail.Message, rawDir string) error {
+	if msg.Raw == "" {
+		return fmt.Errorf("raw message is not available")
+	}
 
-			slog.Info("Downloaded attachment", "filename", att.Filename, "message_id", email.ID)
-		}
+	data, err := decodeBase64URL(msg.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode raw message: %w", err)
+	}
+
+	if rawDir == "" {
+		record.Raw = base64.StdEncoding.EncodeToString(data)
+		return nil
+	}
+
+	rawPath := filepath.Join(rawDir, msg.Id+".eml")
+	if err := os.WriteFile(rawPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write raw message: %w", err)
 	}
+	record.RawFile = rawPath
 
 	return nil
 }
 
+// downloadAttachments saves all attachments of an email into a directory
+// named after the email ID, logging failures without aborting the export
+func downloadAttachments(ctx context.Context, client *Client, email *Email, attachmentsDir string) {
+	emailAttachDir := filepath.Join(attachmentsDir, email.ID)
+	if err := os.MkdirAll(emailAttachDir, 0755); err != nil {
+		slog.Warn("Failed to create email attachment directory", "email_id", email.ID, "error", err)
+		return
+	}
+
+	for _, att := range email.Attachments {
+		// Small parts are returned with the message and have no attachment ID
+		var err error
+		if att.ID == "" {
+			err = saveAttachment(att.Data, att.Filename, emailAttachDir)
+		} else {
+			err = client.DownloadAttachment(ctx, email.ID, att.ID, att.Filename, emailAttachDir)
+		}
+		if err != nil {
+			slog.Warn("Failed to download attachment", "filename", att.Filename, "message_id", email.ID, "error", err)
+			continue
+		}
+
+		slog.Info("Downloaded attachment", "filename", att.Filename, "message_id", email.ID)
+	}
+}
+
 // ParseMessage parses a message with strip options for markdown
 func ParseMessage(msg *gmail.Message, stripImages, stripLinks bool) (*Email, error) {
 	email := &Email{
@@ -122,24 +464,27 @@ func ParseMessage(msg *gmail.Message, stripImages, stripLinks bool) (*Email, err
 		Attachments: []Attachment{},
 	}
 
+	dateHeader := ""
 	headers := msg.Payload.Headers
 	for _, header := range headers {
 		switch header.Name {
 		case "From":
-			email.From = header.Value
+			email.From = decodeAddresses(header.Value)
 		case "To":
-			email.To = header.Value
+			email.To = decodeAddresses(header.Value)
 		case "Subject":
-			email.Subject = header.Value
+			email.Subject = decodeHeader(header.Value)
 		case "Date":
-			parsedTime, err := mail.ParseDate(header.Value)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse date '%s': %w", header.Value, err)
-			}
-			email.Date = parsedTime
+			dateHeader = header.Value
 		}
 	}
 
+	date, source, err := messageDate(dateHeader, msg.InternalDate)
+	if err != nil {
+		slog.Warn("Failed to parse date, using the received date", "id", msg.Id, "date", dateHeader, "error", err)
+	}
+	email.Date, email.DateSource = date, source
+
 	extractContent(msg.Payload, email)
 	if err := convertToMarkdown(email, stripImages, stripLinks); err != nil {
 		return nil, fmt.Errorf("failed to convert to markdown: %w", err)
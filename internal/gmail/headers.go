@@ -0,0 +1,215 @@
+package gmail
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"google.golang.org/api/gmail/v1"
+)
+
+// wordDecoder decodes RFC 2047 encoded words in any charset known to the
+// WHATWG encoding standard, not only the UTF-8 and ISO-8859-1 supported by
+// the standard library
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// addressParser parses address lists, decoding encoded display names
+var addressParser = &mail.AddressParser{WordDecoder: wordDecoder}
+
+// extendedParamPattern matches the RFC 2231 sections of a filename or name
+// parameter, such as filename*=utf-8'en'a.pdf or name*0*=...; name*1=...
+var extendedParamPattern = regexp.MustCompile(`(?i)(?:^|;)\s*(filename|name)\*(\d+)?(\*)?\s*=\s*("(?:[^"\\]|\\.)*"|[^;\s]*)`)
+
+// addressSpecials are the characters that require a display name to be
+// quoted
+const addressSpecials = `()<>[]:;@\,."`
+
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	reader, err := charset.NewReaderLabel(label, input)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", label, err)
+	}
+	return reader, nil
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header value into
+// UTF-8. Values that cannot be decoded are returned as is.
+func decodeHeader(value string) string {
+	if !strings.Contains(value, "=?") {
+		return value
+	}
+
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		slog.Debug("Failed to decode header", "value", value, "error", err)
+		return value
+	}
+	return decoded
+}
+
+// decodeAddresses decodes the display names of an address list header,
+// keeping the list separated by commas
+func decodeAddresses(value string) string {
+	if !strings.Contains(value, "=?") {
+		return value
+	}
+
+	addresses, err := addressParser.ParseList(value)
+	if err != nil {
+		return decodeHeader(value)
+	}
+
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, formatAddress(address))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// formatAddress formats an address like mail.Address.String, but keeps
+// non-ASCII display names in UTF-8 instead of encoding them again
+func formatAddress(address *mail.Address) string {
+	if isASCII(address.Name) {
+		return address.String()
+	}
+
+	name := address.Name
+	if strings.ContainsAny(name, addressSpecials) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + address.Address + ">"
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// partFilename returns the filename of a MIME part decoded into UTF-8,
+// along with its encoded value when it differs. The filename is taken from
+// the RFC 2231 filename* or name* parameters when present, then from the
+// filename or name parameters, which may contain RFC 2047 encoded words, and
+// finally from the filename returned by Gmail, which is already decoded.
+// The encoded value is the parameter as written in the headers. Any
+// directory is stripped, as recommended by RFC 2183.
+func partFilename(part *gmail.MessagePart) (string, string) {
+	filename, raw := "", ""
+	for _, header := range []string{"Content-Disposition", "Content-Type"} {
+		if value, extended := extendedParam(partHeader(part, header)); value != "" {
+			filename, raw = value, extended
+			break
+		}
+	}
+	if filename == "" {
+		raw = plainParam(part)
+		if raw == "" {
+			raw = part.Filename
+		}
+		filename = decodeHeader(raw)
+	}
+
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	if filename == "." || filename == ".." {
+		filename = "_"
+	}
+
+	if raw == filename {
+		raw = ""
+	}
+	return filename, raw
+}
+
+// plainParam returns the filename parameter of the Content-Disposition of a
+// part, or the name parameter of its Content-Type, as written in the header
+func plainParam(part *gmail.MessagePart) string {
+	for _, param := range []struct{ header, name string }{
+		{"Content-Disposition", "filename"},
+		{"Content-Type", "name"},
+	} {
+		_, params, err := mime.ParseMediaType(partHeader(part, param.header))
+		if err == nil && params[param.name] != "" {
+			return params[param.name]
+		}
+	}
+	return ""
+}
+
+// extendedParam decodes the RFC 2231 filename* parameter of a header, or its
+// name* parameter, joining continuations. It returns the decoded value and
+// the extended value as written in the header.
+func extendedParam(header string) (string, string) {
+	type section struct {
+		index   int
+		encoded bool
+		value   string
+	}
+	sections := make(map[string][]section)
+	for _, match := range extendedParamPattern.FindAllStringSubmatch(header, -1) {
+		name := strings.ToLower(match[1])
+		// Single extended values are always encoded, continuations only when
+		// the section number is followed by an asterisk
+		s := section{encoded: match[2] == "" || match[3] != "", value: match[4]}
+		if match[2] != "" {
+			s.index, _ = strconv.Atoi(match[2])
+		}
+		if unquoted, err := strconv.Unquote(s.value); err == nil && strings.HasPrefix(s.value, `"`) {
+			s.value = unquoted
+		}
+		sections[name] = append(sections[name], s)
+	}
+
+	for _, name := range []string{"filename", "name"} {
+		parts := sections[name]
+		if len(parts) == 0 {
+			continue
+		}
+		sort.SliceStable(parts, func(i, j int) bool { return parts[i].index < parts[j].index })
+
+		var raw strings.Builder
+		var data []byte
+		label := ""
+		for i, s := range parts {
+			raw.WriteString(s.value)
+			value := s.value
+			if s.encoded && i == 0 {
+				// The first encoded section starts with charset'language'
+				fields := strings.SplitN(value, "'", 3)
+				if len(fields) == 3 {
+					label, value = fields[0], fields[2]
+				}
+			}
+			if s.encoded {
+				unescaped, err := url.PathUnescape(value)
+				if err == nil {
+					value = unescaped
+				}
+			}
+			data = append(data, value...)
+		}
+
+		value := string(data)
+		if label != "" {
+			if encoding, _ := charset.Lookup(label); encoding != nil {
+				if decoded, err := encoding.NewDecoder().Bytes(data); err == nil {
+					value = string(decoded)
+				}
+			}
+		}
+		return strings.ToValidUTF8(value, "�"), raw.String()
+	}
+	return "", ""
+}
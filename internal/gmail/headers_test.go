@@ -0,0 +1,112 @@
+package gmail
+
+import "testing"
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "Invoice 42", "Invoice 42"},
+		{"base64 utf-8", "=?UTF-8?B?Q2Fmw6k=?=", "Café"},
+		{"quoted-printable latin-1", "=?iso-8859-1?Q?caf=E9_cr=E8me?=", "café crème"},
+		{"adjacent words", "=?UTF-8?Q?a?= =?UTF-8?Q?b?=", "ab"},
+		{"mixed with text", "Re: =?UTF-8?Q?R=C3=A9union?= demain", "Re: Réunion demain"},
+		{"legacy charset", "=?windows-1251?B?z/Do4uXy?=", "Привет"},
+		{"unknown charset", "=?x-unknown?Q?abc?=", "=?x-unknown?Q?abc?="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeHeader(tt.value); got != tt.want {
+				t.Errorf("decodeHeader(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAddresses(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "Alice <alice@example.com>, bob@example.com", "Alice <alice@example.com>, bob@example.com"},
+		{"encoded name", "=?UTF-8?Q?J=C3=A9r=C3=B4me?= <jerome@example.com>", "Jérôme <jerome@example.com>"},
+		{"encoded name with specials", "=?UTF-8?Q?Dupont=2C_J=C3=A9r=C3=B4me?= <jerome@example.com>, bob@example.com", `"Dupont, Jérôme" <jerome@example.com>, <bob@example.com>`},
+		{"unparsable list", "=?UTF-8?Q?J=C3=A9r=C3=B4me?= <jerome@", "Jérôme <jerome@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeAddresses(tt.value); got != tt.want {
+				t.Errorf("decodeAddresses(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedParam(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		want        string
+		wantEncoded string
+	}{
+		{"none", `attachment; filename="a.pdf"`, "", ""},
+		{"single", `attachment; filename*=utf-8''caf%C3%A9.pdf`, "café.pdf", "utf-8''caf%C3%A9.pdf"},
+		{"single with language", `attachment; filename*=iso-8859-1'fr'caf%E9.pdf`, "café.pdf", "iso-8859-1'fr'caf%E9.pdf"},
+		{"continuations", `attachment; filename*0="annual "; filename*1="report.pdf"`, "annual report.pdf", "annual report.pdf"},
+		{"encoded continuations", `attachment; filename*0*=utf-8''r%C3%A9sum; filename*1*=%C3%A9; filename*2=".pdf"`, "résumé.pdf", "utf-8''r%C3%A9sum%C3%A9.pdf"},
+		{"continuations out of order", `attachment; filename*1="b.pdf"; filename*0="a"`, "ab.pdf", "ab.pdf"},
+		{"name parameter", `application/pdf; name*=utf-8''%E2%82%AC.pdf`, "€.pdf", "utf-8''%E2%82%AC.pdf"},
+		{"filename over name", `attachment; name*=utf-8''name.pdf; filename*=utf-8''filename.pdf`, "filename.pdf", "utf-8''filename.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, encoded := extendedParam(tt.header)
+			if got != tt.want || encoded != tt.wantEncoded {
+				t.Errorf("extendedParam(%q) = %q, %q, want %q, %q", tt.header, got, encoded, tt.want, tt.wantEncoded)
+			}
+		})
+	}
+}
+
+func TestPartFilename(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		headers     []string
+		want        string
+		wantEncoded string
+	}{
+		{"gmail filename", "report.pdf", nil, "report.pdf", ""},
+		{"encoded words", "=?UTF-8?Q?caf=C3=A9.pdf?=", nil, "café.pdf", "=?UTF-8?Q?caf=C3=A9.pdf?="},
+		{"rfc 2231 disposition", "café.pdf", []string{"Content-Disposition", `attachment; filename*=iso-8859-1''caf%E9.pdf`}, "café.pdf", "iso-8859-1''caf%E9.pdf"},
+		{"rfc 2231 continuations", "résumé.pdf", []string{"Content-Disposition", `attachment; filename*0*=utf-8''r%C3%A9sum; filename*1*=%C3%A9.pdf`}, "résumé.pdf", "utf-8''r%C3%A9sum%C3%A9.pdf"},
+		{"rfc 2047 disposition", "café.pdf", []string{"Content-Disposition", `attachment; filename="=?UTF-8?Q?caf=C3=A9.pdf?="`}, "café.pdf", "=?UTF-8?Q?caf=C3=A9.pdf?="},
+		{"rfc 2047 content type", "café.pdf", []string{"Content-Type", `application/pdf; name="=?UTF-8?B?Y2Fmw6kucGRm?="`}, "café.pdf", "=?UTF-8?B?Y2Fmw6kucGRm?="},
+		{"disposition over content type", "a.pdf", []string{"Content-Type", `application/pdf; name="b.pdf"`, "Content-Disposition", `attachment; filename="a.pdf"`}, "a.pdf", ""},
+		{"plain parameter", "report.pdf", []string{"Content-Disposition", `attachment; filename="report.pdf"`}, "report.pdf", ""},
+		{"rfc 2231 content type", "", []string{"Content-Type", `application/pdf; name*=utf-8''caf%C3%A9.pdf`}, "café.pdf", "utf-8''caf%C3%A9.pdf"},
+		{"unix directories", "../../etc/passwd", nil, "passwd", "../../etc/passwd"},
+		{"windows directories", `C:\Users\me\report.pdf`, nil, "report.pdf", `C:\Users\me\report.pdf`},
+		{"encoded directories", "", []string{"Content-Disposition", `attachment; filename*=utf-8''..%2F..%2Fa.pdf`}, "a.pdf", "utf-8''..%2F..%2Fa.pdf"},
+		{"dot", ".", nil, "_", "."},
+		{"dot dot", "a/..", nil, "_", "a/.."},
+		{"none", "", nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part := withFilename(testPart("1", "application/pdf", tt.headers...), tt.filename)
+
+			got, encoded := partFilename(part)
+			if got != tt.want || encoded != tt.wantEncoded {
+				t.Errorf("partFilename(%q) = %q, %q, want %q, %q", tt.filename, got, encoded, tt.want, tt.wantEncoded)
+			}
+		})
+	}
+}
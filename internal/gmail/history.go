@@ -0,0 +1,174 @@
+package gmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Event types recorded for changes reported by the History API
+const (
+	EventDeleted       = "deleted"
+	EventLabelsAdded   = "labels_added"
+	EventLabelsRemoved = "labels_removed"
+)
+
+// ErrHistoryExpired is returned when the stored history ID is too old for
+// the History API and a full export is required
+var ErrHistoryExpired = errors.New("history ID is no longer available, a full export is required")
+
+// HistoryChanges contains the changes made to a mailbox since a history ID
+type HistoryChanges struct {
+	// AddedIDs lists the messages added since the start history ID, in the
+	// order they were added
+	AddedIDs []string
+	// Events lists deletions and label changes in chronological order
+	Events []JSONLEvent
+	// HistoryID is the mailbox history ID the changes are current up to
+	HistoryID uint64
+}
+
+// SyncState records where an incremental export stopped
+type SyncState struct {
+	HistoryID uint64    `json:"history_id"`
+	LabelID   string    `json:"label_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetHistoryID returns the current history ID of the mailbox
+func (c *Client) GetHistoryID(ctx context.Context) (uint64, error) {
+	user := "me"
+	profile, err := withRetry(ctx, c, quotaGetProfile, func() (*gmail.Profile, error) {
+		return c.service.Users.GetProfile(user).Context(ctx).Do()
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve profile: %v", err)
+	}
+
+	return profile.HistoryId, nil
+}
+
+// ListHistory returns the messages added, deleted and relabeled since the
+// given history ID, restricted to a label when labelID is not empty. Messages
+// the label was added to count as added, and messages that lost the label or
+// were deleted afterwards are not listed as added.
+func (c *Client) ListHistory(ctx context.Context, startHistoryID uint64, labelID string) (*HistoryChanges, error) {
+	user := "me"
+	changes := &HistoryChanges{HistoryID: startHistoryID}
+	// added tells whether each message listed in AddedIDs is still to be
+	// fetched
+	added := make(map[string]bool)
+	addMessage := func(id string) {
+		if _, ok := added[id]; !ok {
+			changes.AddedIDs = append(changes.AddedIDs, id)
+		}
+		added[id] = true
+	}
+	dropMessage := func(id string) {
+		if _, ok := added[id]; ok {
+			added[id] = false
+		}
+	}
+
+	call := c.service.Users.History.List(user).
+		StartHistoryId(startHistoryID).
+		HistoryTypes("messageAdded", "messageDeleted", "labelAdded", "labelRemoved").
+		MaxResults(500)
+	if labelID != "" {
+		call = call.LabelId(labelID)
+	}
+
+	for {
+		response, err := withRetry(ctx, c, quotaHistoryList, func() (*gmail.ListHistoryResponse, error) {
+			return call.Context(ctx).Do()
+		})
+		if err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+				return nil, ErrHistoryExpired
+			}
+			return nil, fmt.Errorf("unable to retrieve history: %v", err)
+		}
+
+		for _, history := range response.History {
+			for _, change := range history.MessagesAdded {
+				addMessage(change.Message.Id)
+			}
+
+			for _, change := range history.MessagesDeleted {
+				dropMessage(change.Message.Id)
+				changes.Events = append(changes.Events, newJSONLEvent(EventDeleted, history.Id, change.Message, nil))
+			}
+
+			for _, change := range history.LabelsAdded {
+				// Labelling an existing message brings it into the label
+				if labelID != "" && slices.Contains(change.LabelIds, labelID) {
+					addMessage(change.Message.Id)
+				}
+				changes.Events = append(changes.Events, newJSONLEvent(EventLabelsAdded, history.Id, change.Message, change.LabelIds))
+			}
+
+			for _, change := range history.LabelsRemoved {
+				if labelID != "" && slices.Contains(change.LabelIds, labelID) {
+					dropMessage(change.Message.Id)
+				}
+				changes.Events = append(changes.Events, newJSONLEvent(EventLabelsRemoved, history.Id, change.Message, change.LabelIds))
+			}
+		}
+
+		if response.HistoryId > changes.HistoryID {
+			changes.HistoryID = response.HistoryId
+		}
+
+		if response.NextPageToken == "" {
+			break
+		}
+		call = call.PageToken(response.NextPageToken)
+	}
+
+	changes.AddedIDs = slices.DeleteFunc(changes.AddedIDs, func(id string) bool { return !added[id] })
+	return changes, nil
+}
+
+// LoadSyncState reads the sync state stored at path, returning nil when no
+// incremental export has completed yet
+func LoadSyncState(path string) (*SyncState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	state := &SyncState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Save atomically writes the sync state to path
+func (s *SyncState) Save(path string) error {
+	s.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync state: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+
+	return nil
+}
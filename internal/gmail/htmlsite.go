@@ -0,0 +1,475 @@
+package gmail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Directories of an html-site export
+const (
+	// siteDataDir holds one JSON file per exported email, from which the
+	// pages are rebuilt so that resumed and incremental exports update the
+	// site
+	siteDataDir    = "data"
+	siteThreadsDir = "threads"
+)
+
+// SiteAttachmentsDir is the directory of an html-site export attachments are
+// downloaded to when no other directory is configured
+const SiteAttachmentsDir = "attachments"
+
+// searchTextLength is the number of body characters of each email included
+// in the search index
+const searchTextLength = 5000
+
+// cidPattern matches cid: URLs referencing inline attachments
+var cidPattern = regexp.MustCompile(`(?i)\bcid:([^"'\s>)]+)`)
+
+// siteMessage is an email stored in the data directory of an html-site
+// export, with its body already sanitized
+type siteMessage struct {
+	ID          string           `json:"id"`
+	ThreadID    string           `json:"thread_id"`
+	Date        time.Time        `json:"date"`
+	From        string           `json:"from"`
+	To          []string         `json:"to"`
+	Cc          []string         `json:"cc,omitempty"`
+	Subject     string           `json:"subject"`
+	LabelIDs    []string         `json:"label_ids"`
+	Body        string           `json:"body"`
+	Text        string           `json:"text"`
+	Attachments []siteAttachment `json:"attachments,omitempty"`
+}
+
+type siteAttachment struct {
+	Filename string `json:"filename"`
+	// Href is the path of the downloaded attachment relative to the thread
+	// pages, empty when attachments are not downloaded
+	Href string `json:"href,omitempty"`
+}
+
+// siteThread is a conversation rendered as a thread page and an index row
+type siteThread struct {
+	ID       string
+	Subject  string
+	Sender   string
+	LastDate time.Time
+	Messages []siteMessage
+}
+
+// htmlSiteWriter renders emails into a static website with an index page,
+// one page per thread and a client-side search index. Pages are rendered
+// when the writer is closed.
+type htmlSiteWriter struct {
+	dir            string
+	attachmentsDir string
+	labelNames     map[string]string
+	policy         *bluemonday.Policy
+}
+
+func newHTMLSiteWriter(options ExportOptions) (*htmlSiteWriter, error) {
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported by the html-site format")
+	}
+	if options.OutputFile == StdoutOutput {
+		return nil, fmt.Errorf("the html-site format writes to a directory and cannot write to the standard output")
+	}
+
+	for _, sub := range []string{siteDataDir, siteThreadsDir} {
+		if err := os.MkdirAll(filepath.Join(options.OutputFile, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create site directory: %w", err)
+		}
+	}
+
+	policy := bluemonday.UGCPolicy()
+	policy.AddTargetBlankToFullyQualifiedLinks(true)
+
+	w := &htmlSiteWriter{
+		dir:        options.OutputFile,
+		labelNames: options.LabelNames,
+		policy:     policy,
+	}
+	if options.IncludeAttachments {
+		// Attachments are linked relative to the pages, which would break
+		// once the site is moved without them
+		inside, err := isWithinDir(options.OutputFile, options.AttachmentsDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve attachments directory: %w", err)
+		}
+		if !inside {
+			return nil, fmt.Errorf("the attachments directory of the html-site format must be within the --output directory")
+		}
+		w.attachmentsDir = options.AttachmentsDir
+	}
+	return w, nil
+}
+
+func (w *htmlSiteWriter) Write(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+
+	message := siteMessage{
+		ID:       record.ID,
+		ThreadID: record.ThreadID,
+		Date:     email.Date,
+		From:     record.From,
+		To:       record.To,
+		Cc:       record.Cc,
+		Subject:  record.Subject,
+		LabelIDs: record.LabelIDs,
+	}
+
+	hrefs := make(map[string]string)
+	for _, att := range record.Attachments {
+		attachment := siteAttachment{Filename: att.Filename}
+		if w.attachmentsDir != "" {
+			target := filepath.Join(w.attachmentsDir, msg.Id, att.Filename)
+			if href, err := relativePath(filepath.Join(w.dir, siteThreadsDir), target); err == nil {
+				attachment.Href = href
+				hrefs[att.PartID] = href
+			}
+		}
+		message.Attachments = append(message.Attachments, attachment)
+	}
+
+	if email.HTMLBody != "" {
+		// Inline images reference attachments by Content-ID, resolve them
+		// to the downloaded files before sanitizing
+		cids := contentIDs(msg.Payload)
+		body := cidPattern.ReplaceAllStringFunc(email.HTMLBody, func(match string) string {
+			if href, ok := hrefs[cids[strings.ToLower(match[len("cid:"):])]]; ok {
+				return href
+			}
+			return match
+		})
+		message.Body = w.policy.Sanitize(body)
+	} else {
+		message.Body = "<pre>" + template.HTMLEscapeString(email.Body) + "</pre>"
+	}
+
+	text := email.Body
+	if text == htmlOnlyBody {
+		text = email.MarkdownBody
+	}
+	if runes := []rune(text); len(runes) > searchTextLength {
+		text = string(runes[:searchTextLength])
+	}
+	message.Text = text
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := os.WriteFile(w.dataPath(msg.Id), data, 0644); err != nil {
+		return fmt.Errorf("failed to write message data: %w", err)
+	}
+	return nil
+}
+
+// WriteEvent removes deleted emails from the site and updates the labels of
+// the others
+func (w *htmlSiteWriter) WriteEvent(event JSONLEvent) error {
+	path := w.dataPath(event.ID)
+	if event.Event == EventDeleted {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove deleted message: %w", err)
+		}
+		return nil
+	}
+
+	message, err := readSiteMessage(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	message.LabelIDs = event.LabelIDs
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write message data: %w", err)
+	}
+	return nil
+}
+
+func (w *htmlSiteWriter) Flush() (int64, error) {
+	return 0, nil
+}
+
+// Close renders the pages of every email stored in the data directory
+func (w *htmlSiteWriter) Close() error {
+	paths, err := filepath.Glob(filepath.Join(w.dir, siteDataDir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list message data: %w", err)
+	}
+
+	threads := make(map[string]*siteThread)
+	for _, path := range paths {
+		message, err := readSiteMessage(path)
+		if err != nil {
+			return err
+		}
+
+		thread, ok := threads[message.ThreadID]
+		if !ok {
+			thread = &siteThread{ID: message.ThreadID}
+			threads[message.ThreadID] = thread
+		}
+		thread.Messages = append(thread.Messages, message)
+	}
+
+	sorted := make([]*siteThread, 0, len(threads))
+	searchIndex := make(map[string]string, len(threads))
+	for _, thread := range threads {
+		sort.SliceStable(thread.Messages, func(i, j int) bool {
+			return thread.Messages[i].Date.Before(thread.Messages[j].Date)
+		})
+		first, last := thread.Messages[0], thread.Messages[len(thread.Messages)-1]
+		thread.Subject = first.Subject
+		thread.Sender = displayName(first.From)
+		thread.LastDate = last.Date
+		sorted = append(sorted, thread)
+
+		var text strings.Builder
+		for _, message := range thread.Messages {
+			fmt.Fprintf(&text, "%s\n%s\n%s\n%s\n", message.Subject, message.From, strings.Join(message.To, " "), message.Text)
+		}
+		searchIndex[thread.ID] = strings.ToLower(text.String())
+
+		if err := w.render(filepath.Join(siteThreadsDir, thread.ID+".html"), threadTemplate, thread); err != nil {
+			return err
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LastDate.After(sorted[j].LastDate) })
+
+	// Drop the pages of threads whose emails were all deleted
+	pages, _ := filepath.Glob(filepath.Join(w.dir, siteThreadsDir, "*.html"))
+	for _, page := range pages {
+		if _, ok := threads[strings.TrimSuffix(filepath.Base(page), ".html")]; !ok {
+			_ = os.Remove(page)
+		}
+	}
+
+	index, err := json.Marshal(searchIndex)
+	if err != nil {
+		return fmt.Errorf("failed to encode search index: %w", err)
+	}
+	script := "const SEARCH_INDEX = " + string(index) + ";\n"
+	if err := os.WriteFile(filepath.Join(w.dir, "search-index.js"), []byte(script), 0644); err != nil {
+		return fmt.Errorf("failed to write search index: %w", err)
+	}
+
+	return w.render("index.html", indexTemplate, sorted)
+}
+
+func (w *htmlSiteWriter) dataPath(id string) string {
+	return filepath.Join(w.dir, siteDataDir, id+".json")
+}
+
+func (w *htmlSiteWriter) render(name string, tmpl *template.Template, data any) error {
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to create page: %w", err)
+	}
+
+	if err := tmpl.Execute(file, map[string]any{"Data": data, "LabelNames": w.labelNames}); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return file.Close()
+}
+
+func readSiteMessage(path string) (siteMessage, error) {
+	var message siteMessage
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return message, fmt.Errorf("failed to read message data: %w", err)
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return message, fmt.Errorf("failed to decode message data %s: %w", path, err)
+	}
+	return message, nil
+}
+
+// isWithinDir reports whether path is dir or one of its descendants
+func isWithinDir(dir, path string) (bool, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false, err
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+// contentIDs maps the lowercased Content-ID of each attachment part to its
+// part ID
+func contentIDs(part *gmail.MessagePart) map[string]string {
+	cids := make(map[string]string)
+	var walk func(part *gmail.MessagePart)
+	walk = func(part *gmail.MessagePart) {
+		if role := partRole(part); role == PartRoleInline || role == PartRoleAttachment {
+			for _, header := range part.Headers {
+				if strings.EqualFold(header.Name, "Content-ID") || strings.EqualFold(header.Name, "X-Attachment-Id") {
+					cid := strings.ToLower(strings.Trim(strings.TrimSpace(header.Value), "<>"))
+					cids[cid] = part.PartId
+				}
+			}
+		}
+		for _, child := range part.Parts {
+			walk(child)
+		}
+	}
+	if part != nil {
+		walk(part)
+	}
+	return cids
+}
+
+// displayName returns the name of an address, or the address itself when it
+// has no name
+func displayName(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	if parsed.Name != "" {
+		return parsed.Name
+	}
+	return parsed.Address
+}
+
+var siteFuncs = template.FuncMap{
+	"date":  func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"iso":   func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"join":  func(values []string) string { return strings.Join(values, ", ") },
+	"html":  func(value string) template.HTML { return template.HTML(value) },
+	"lower": strings.ToLower,
+	"labels": func(labelIDs []string, names map[string]string) string {
+		labels := make([]string, 0, len(labelIDs))
+		for _, labelID := range labelIDs {
+			if name, ok := names[labelID]; ok && name != "" {
+				labelID = name
+			}
+			labels = append(labels, labelID)
+		}
+		return strings.Join(labels, ", ")
+	},
+}
+
+const siteStyle = `
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0 auto; max-width: 1100px; padding: 1rem; color: #222; }
+a { color: #1a5fb4; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: .4rem .6rem; border-bottom: 1px solid #ddd; text-align: left; vertical-align: top; }
+th { cursor: pointer; user-select: none; background: #f4f4f4; }
+th[data-order="asc"]::after { content: " ▲"; }
+th[data-order="desc"]::after { content: " ▼"; }
+input[type=search] { width: 100%; padding: .5rem; margin: 1rem 0; font-size: 1rem; box-sizing: border-box; }
+.message { border: 1px solid #ddd; border-radius: 4px; margin: 1rem 0; }
+.message header { background: #f4f4f4; padding: .6rem .8rem; }
+.message header div { margin: .1rem 0; }
+.message .body { padding: .8rem; overflow-x: auto; }
+.message .body pre { white-space: pre-wrap; }
+.message .body img { max-width: 100%; height: auto; }
+.attachments { padding: .6rem .8rem; border-top: 1px solid #ddd; }
+.muted { color: #666; }
+`
+
+var indexTemplate = template.Must(template.New("index").Funcs(siteFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Email archive</title>
+<style>` + siteStyle + `</style>
+</head>
+<body>
+<h1>Email archive</h1>
+<p class="muted">Conversations: {{len .Data}}</p>
+<input type="search" id="search" placeholder="Search subjects, senders, recipients and bodies">
+<table>
+<thead>
+<tr><th data-key="date" data-order="desc">Date</th><th data-key="sender">Sender</th><th data-key="subject">Subject</th><th>Messages</th></tr>
+</thead>
+<tbody id="threads">
+{{range .Data}}<tr data-thread="{{.ID}}" data-date="{{iso .LastDate}}" data-sender="{{lower .Sender}}" data-subject="{{lower .Subject}}">
+<td>{{date .LastDate}}</td><td>{{.Sender}}</td><td><a href="threads/{{.ID}}.html">{{if .Subject}}{{.Subject}}{{else}}(no subject){{end}}</a></td><td>{{len .Messages}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+<script src="search-index.js"></script>
+<script>
+const tbody = document.getElementById("threads");
+const rows = Array.from(tbody.rows);
+
+document.querySelectorAll("th[data-key]").forEach(th => {
+  th.addEventListener("click", () => {
+    const key = th.dataset.key;
+    const order = th.dataset.order === "asc" ? "desc" : "asc";
+    document.querySelectorAll("th[data-key]").forEach(other => delete other.dataset.order);
+    th.dataset.order = order;
+    rows.sort((a, b) => a.dataset[key].localeCompare(b.dataset[key]) * (order === "asc" ? 1 : -1));
+    rows.forEach(row => tbody.appendChild(row));
+  });
+});
+
+document.getElementById("search").addEventListener("input", event => {
+  const terms = event.target.value.toLowerCase().split(/\s+/).filter(Boolean);
+  rows.forEach(row => {
+    const text = SEARCH_INDEX[row.dataset.thread] || "";
+    row.hidden = !terms.every(term => text.includes(term));
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+var threadTemplate = template.Must(template.New("thread").Funcs(siteFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{if .Data.Subject}}{{.Data.Subject}}{{else}}(no subject){{end}}</title>
+<style>` + siteStyle + `</style>
+</head>
+<body>
+<p><a href="../index.html">← All conversations</a></p>
+<h1>{{if .Data.Subject}}{{.Data.Subject}}{{else}}(no subject){{end}}</h1>
+{{$names := .LabelNames}}{{range .Data.Messages}}<article class="message" id="{{.ID}}">
+<header>
+<div><strong>{{.From}}</strong></div>
+<div class="muted">To: {{join .To}}</div>
+{{if .Cc}}<div class="muted">Cc: {{join .Cc}}</div>{{end}}
+<div class="muted">{{date .Date}}{{if .LabelIDs}} · {{labels .LabelIDs $names}}{{end}}</div>
+{{if ne .Subject $.Data.Subject}}<div>{{.Subject}}</div>{{end}}
+</header>
+<div class="body">{{html .Body}}</div>
+{{if .Attachments}}<div class="attachments">Attachments:
+<ul>{{range .Attachments}}<li>{{if .Href}}<a href="{{.Href}}">{{.Filename}}</a>{{else}}{{.Filename}}{{end}}</li>{{end}}</ul>
+</div>{{end}}
+</article>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,60 @@
+package gmail
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRelativePath(t *testing.T) {
+	dir := filepath.Join("site", "threads")
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"plain", filepath.Join("site", "attachments", "id", "report.pdf"), "../attachments/id/report.pdf"},
+		{"spaces", filepath.Join("site", "attachments", "id", "annual report.pdf"), "../attachments/id/annual%20report.pdf"},
+		{"reserved characters", filepath.Join("site", "attachments", "id", `a#b?c%d"e'f.pdf`), "../attachments/id/a%23b%3Fc%25d%22e%27f.pdf"},
+		{"non-ascii", filepath.Join("site", "attachments", "id", "été.pdf"), "../attachments/id/%C3%A9t%C3%A9.pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := relativePath(dir, tt.target)
+			if err != nil {
+				t.Fatalf("relativePath(%q) returned error: %v", tt.target, err)
+			}
+			if got != tt.want {
+				t.Errorf("relativePath(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWithinDir(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"site", true},
+		{"site/attachments", true},
+		{"site/../site/attachments", true},
+		{"site/..attachments", true},
+		{"attachments", false},
+		{"site-attachments", false},
+		{"site/../attachments", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := isWithinDir("site", filepath.FromSlash(tt.path))
+			if err != nil {
+				t.Fatalf("isWithinDir(%q) returned error: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("isWithinDir(%q) = %t, want %t", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package gmail
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/mail"
+	"os"
 	"strings"
 
 	"google.golang.org/api/gmail/v1"
@@ -9,18 +13,29 @@ import (
 
 // JSONLEmail represents the email structure for JSONL export
 type JSONLEmail struct {
-	ID          string               `json:"id"`
-	ThreadID    string               `json:"thread_id"`
-	LabelIDs    []string             `json:"label_ids"`
-	Subject     string               `json:"subject"`
-	From        string               `json:"from"`
-	To          []string             `json:"to"`
-	Cc          []string             `json:"cc,omitempty"`
-	Bcc         []string             `json:"bcc,omitempty"`
-	Date        string               `json:"date"`
-	Body        BodyFormats          `json:"body"`
-	Attachments []AttachmentMetadata `json:"attachments,omitempty"`
-	Headers     map[string]string    `json:"headers"`
+	SchemaVersion int                  `json:"schema_version"`
+	ID            string               `json:"id"`
+	ThreadID      string               `json:"thread_id"`
+	LabelIDs      []string             `json:"label_ids"`
+	Subject       string               `json:"subject"`
+	From          string               `json:"from"`
+	To            []string             `json:"to"`
+	Cc            []string             `json:"cc,omitempty"`
+	Bcc           []string             `json:"bcc,omitempty"`
+	Date          string               `json:"date"`
+	DateSource    string               `json:"date_source,omitempty"`
+	Body          BodyFormats          `json:"body"`
+	Attachments   []AttachmentMetadata `json:"attachments,omitempty"`
+	Headers       map[string]string    `json:"headers"`
+	// EmbeddedMessages are the emails forwarded as message/rfc822 parts
+	EmbeddedMessages []JSONLEmbeddedMessage `json:"embedded_messages,omitempty"`
+	// Parts describes every MIME part of the email, when requested
+	Parts []MIMEPart `json:"parts,omitempty"`
+	// Raw is the RFC 822 source of the email encoded in standard base64
+	Raw string `json:"raw,omitempty"`
+	// RawFile is the path of the .eml file holding the RFC 822 source when
+	// it is written to a sidecar file instead of inlined
+	RawFile string `json:"raw_file,omitempty"`
 }
 
 // BodyFormats contains all body format variations
@@ -28,70 +43,240 @@ type BodyFormats struct {
 	Text     string `json:"text"`
 	HTML     string `json:"html"`
 	Markdown string `json:"markdown"`
+	// TextCharset and HTMLCharset are the charsets the bodies were decoded
+	// from into UTF-8
+	TextCharset string `json:"text_charset,omitempty"`
+	HTMLCharset string `json:"html_charset,omitempty"`
 }
 
 // AttachmentMetadata contains detailed attachment information
 type AttachmentMetadata struct {
 	ID       string `json:"id"`
 	Filename string `json:"filename"`
-	MimeType string `json:"mime_type"`
-	Size     int64  `json:"size"`
+	// EncodedFilename is the filename before RFC 2047 or RFC 2231 decoding,
+	// when it differs from Filename
+	EncodedFilename string `json:"encoded_filename,omitempty"`
+	MimeType        string `json:"mime_type"`
+	Size            int64  `json:"size"`
+	PartID          string `json:"part_id,omitempty"`
+	// Role is attachment or inline, for parts displayed within the body
+	Role      string `json:"role,omitempty"`
+	ContentID string `json:"content_id,omitempty"`
 }
 
-func convertToJSONL(msg *gmail.Message, email *Email) JSONLEmail {
-	headers := make(map[string]string)
-	for _, header := range msg.Payload.Headers {
-		headers[header.Name] = header.Value
+// JSONLEmbeddedMessage represents an email forwarded as a message/rfc822
+// part, which may itself embed forwarded emails
+type JSONLEmbeddedMessage struct {
+	PartID           string                 `json:"part_id"`
+	Subject          string                 `json:"subject"`
+	From             string                 `json:"from"`
+	To               []string               `json:"to"`
+	Cc               []string               `json:"cc,omitempty"`
+	Date             string                 `json:"date,omitempty"`
+	Body             BodyFormats            `json:"body"`
+	Attachments      []AttachmentMetadata   `json:"attachments,omitempty"`
+	Headers          map[string]string      `json:"headers"`
+	EmbeddedMessages []JSONLEmbeddedMessage `json:"embedded_messages,omitempty"`
+}
+
+// JSONLThread represents a conversation for thread-level JSONL export, with
+// its messages in chronological order
+type JSONLThread struct {
+	SchemaVersion int          `json:"schema_version"`
+	ID            string       `json:"id"`
+	Subject       string       `json:"subject"`
+	Participants  []string     `json:"participants"`
+	LabelIDs      []string     `json:"label_ids"`
+	FirstDate     string       `json:"first_date"`
+	LastDate      string       `json:"last_date"`
+	MessageCount  int          `json:"message_count"`
+	Messages      []JSONLEmail `json:"messages"`
+}
+
+// JSONLEvent represents a change to a previously exported email, as reported
+// by the History API during an incremental export
+type JSONLEvent struct {
+	SchemaVersion int      `json:"schema_version"`
+	ID            string   `json:"id"`
+	ThreadID      string   `json:"thread_id"`
+	Event         string   `json:"event"`
+	HistoryID     uint64   `json:"history_id"`
+	LabelIDs      []string `json:"label_ids,omitempty"`
+	// Changed lists the labels added or removed by a label change event
+	Changed []string `json:"changed_label_ids,omitempty"`
+}
+
+func newJSONLEvent(event string, historyID uint64, msg *gmail.Message, changed []string) JSONLEvent {
+	return JSONLEvent{
+		SchemaVersion: SchemaVersion,
+		ID:            msg.Id,
+		ThreadID:      msg.ThreadId,
+		Event:         event,
+		HistoryID:     historyID,
+		LabelIDs:      msg.LabelIds,
+		Changed:       changed,
 	}
+}
+
+func convertToJSONL(msg *gmail.Message, email *Email) JSONLEmail {
+	headers := headerMap(msg.Payload.Headers)
 
 	to := parseRecipients(headers["To"])
 	cc := parseRecipients(headers["Cc"])
 	bcc := parseRecipients(headers["Bcc"])
 
-	var attachments []AttachmentMetadata
-	for _, att := range email.Attachments {
-		attachments = append(attachments, AttachmentMetadata{
-			ID:       att.ID,
-			Filename: att.Filename,
-			MimeType: att.MimeType,
-			Size:     att.Size,
-		})
-	}
-
 	return JSONLEmail{
-		ID:       msg.Id,
-		ThreadID: msg.ThreadId,
-		LabelIDs: msg.LabelIds,
-		Subject:  email.Subject,
-		From:     email.From,
-		To:       to,
-		Cc:       cc,
-		Bcc:      bcc,
-		Date:     email.Date.Format("2006-01-02T15:04:05Z07:00"),
+		SchemaVersion: SchemaVersion,
+		ID:            msg.Id,
+		ThreadID:      msg.ThreadId,
+		LabelIDs:      msg.LabelIds,
+		Subject:       email.Subject,
+		From:          email.From,
+		To:            to,
+		Cc:            cc,
+		Bcc:           bcc,
+		Date:          email.Date.Format("2006-01-02T15:04:05Z07:00"),
+		DateSource:    email.DateSource,
 		Body: BodyFormats{
-			Text:     email.Body,
-			HTML:     email.HTMLBody,
-			Markdown: email.MarkdownBody,
+			Text:        email.Body,
+			HTML:        email.HTMLBody,
+			Markdown:    email.MarkdownBody,
+			TextCharset: email.TextCharset,
+			HTMLCharset: email.HTMLCharset,
 		},
-		Attachments: attachments,
-		Headers:     headers,
+		Attachments:      attachmentMetadata(email.Attachments),
+		Headers:          headers,
+		EmbeddedMessages: convertEmbeddedToJSONL(email.EmbeddedMessages),
 	}
 }
 
+// convertEmbeddedToJSONL converts forwarded emails and the emails they
+// forward in turn
+func convertEmbeddedToJSONL(emails []*Email) []JSONLEmbeddedMessage {
+	var records []JSONLEmbeddedMessage
+	for _, email := range emails {
+		headers := headerMap(email.Headers)
+		record := JSONLEmbeddedMessage{
+			PartID:  email.PartID,
+			Subject: email.Subject,
+			From:    email.From,
+			To:      parseRecipients(headers["To"]),
+			Cc:      parseRecipients(headers["Cc"]),
+			Body: BodyFormats{
+				Text:        email.Body,
+				HTML:        email.HTMLBody,
+				Markdown:    email.MarkdownBody,
+				TextCharset: email.TextCharset,
+				HTMLCharset: email.HTMLCharset,
+			},
+			Attachments:      attachmentMetadata(email.Attachments),
+			Headers:          headers,
+			EmbeddedMessages: convertEmbeddedToJSONL(email.EmbeddedMessages),
+		}
+		if !email.Date.IsZero() {
+			record.Date = email.Date.Format("2006-01-02T15:04:05Z07:00")
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// headerMap indexes headers by name, the last value of repeated headers
+// winning
+func headerMap(headers []*gmail.MessagePartHeader) map[string]string {
+	result := make(map[string]string)
+	for _, header := range headers {
+		result[header.Name] = header.Value
+	}
+	return result
+}
+
+func attachmentMetadata(attachments []Attachment) []AttachmentMetadata {
+	var result []AttachmentMetadata
+	for _, att := range attachments {
+		result = append(result, AttachmentMetadata{
+			ID:              att.ID,
+			Filename:        att.Filename,
+			EncodedFilename: att.EncodedFilename,
+			MimeType:        att.MimeType,
+			Size:            att.Size,
+			PartID:          att.PartID,
+			Role:            att.Role,
+			ContentID:       att.ContentID,
+		})
+	}
+	return result
+}
+
+func convertThreadToJSONL(threadID string, messages []*gmail.Message, emails []*Email) JSONLThread {
+	thread := JSONLThread{
+		SchemaVersion: SchemaVersion,
+		ID:            threadID,
+		Participants:  []string{},
+		LabelIDs:      []string{},
+		MessageCount:  len(emails),
+		Messages:      make([]JSONLEmail, 0, len(emails)),
+	}
+
+	seenParticipants := make(map[string]struct{})
+	seenLabels := make(map[string]struct{})
+	var first, last *Email
+	for i, email := range emails {
+		record := convertToJSONL(messages[i], email)
+		thread.Messages = append(thread.Messages, record)
+
+		if thread.Subject == "" {
+			thread.Subject = record.Subject
+		}
+
+		for _, participant := range append(append(parseRecipients(record.From), record.To...), record.Cc...) {
+			key := strings.ToLower(participant)
+			if address, err := mail.ParseAddress(participant); err == nil {
+				key = strings.ToLower(address.Address)
+			}
+			if _, ok := seenParticipants[key]; !ok {
+				seenParticipants[key] = struct{}{}
+				thread.Participants = append(thread.Participants, participant)
+			}
+		}
+
+		for _, labelID := range record.LabelIDs {
+			if _, ok := seenLabels[labelID]; !ok {
+				seenLabels[labelID] = struct{}{}
+				thread.LabelIDs = append(thread.LabelIDs, labelID)
+			}
+		}
+
+		if first == nil || email.Date.Before(first.Date) {
+			first = email
+		}
+		if last == nil || email.Date.After(last.Date) {
+			last = email
+		}
+	}
+
+	if first != nil {
+		thread.FirstDate = first.Date.Format("2006-01-02T15:04:05Z07:00")
+		thread.LastDate = last.Date.Format("2006-01-02T15:04:05Z07:00")
+	}
+
+	return thread
+}
+
 func parseRecipients(recipients string) []string {
 	if recipients == "" {
 		return nil
 	}
 
-	// Use the standard library mail parser
-	addressList, err := mail.ParseAddressList(recipients)
+	// Use the standard library mail parser, decoding encoded display names
+	addressList, err := addressParser.ParseList(recipients)
 	if err != nil {
 		// Fallback to simple comma split if parsing fails
 		// This handles malformed addresses gracefully
 		var result []string
 		for _, addr := range strings.Split(recipients, ",") {
 			if trimmed := strings.TrimSpace(addr); trimmed != "" {
-				result = append(result, trimmed)
+				result = append(result, decodeHeader(trimmed))
 			}
 		}
 		return result
@@ -100,7 +285,63 @@ func parseRecipients(recipients string) []string {
 	var result []string
 	for _, addr := range addressList {
 		// Return the full address string (includes name if present)
-		result = append(result, addr.String())
+		result = append(result, formatAddress(addr))
 	}
 	return result
 }
+
+// jsonlWriter writes one JSON record per line
+type jsonlWriter struct {
+	out     *streamOutput
+	options ExportOptions
+}
+
+func newJSONLWriter(options ExportOptions) (*jsonlWriter, error) {
+	if options.IncludeRaw && options.RawDir != "" {
+		if err := os.MkdirAll(options.RawDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create raw messages directory: %w", err)
+		}
+	}
+
+	out, err := newStreamOutput(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonlWriter{out: out, options: options}, nil
+}
+
+func (w *jsonlWriter) Write(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+	addOptionalFields(&record, msg, w.options)
+
+	return w.writeRecord(msg.Id, record)
+}
+
+func (w *jsonlWriter) WriteEvent(event JSONLEvent) error {
+	return w.writeRecord(event.ID, event)
+}
+
+// writeRecord writes a record as a JSON line. Records that cannot be
+// marshalled are logged and skipped.
+func (w *jsonlWriter) writeRecord(id string, record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		slog.Warn("Failed to marshal record", "id", id, "error", err)
+		return nil
+	}
+
+	data = append(data, '\n')
+	if _, err := w.out.Write(data); err != nil {
+		return fmt.Errorf("failed to write JSON line: %w", err)
+	}
+	return nil
+}
+
+func (w *jsonlWriter) Flush() (int64, error) {
+	return w.out.Flush()
+}
+
+func (w *jsonlWriter) Close() error {
+	return w.out.Close()
+}
@@ -0,0 +1,214 @@
+package gmail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// maildirFlags maps Gmail labels to Maildir info flags, a message without
+// the UNREAD label is marked as seen
+var maildirFlags = map[string]byte{
+	"DRAFT":   'D',
+	"STARRED": 'F',
+	"TRASH":   'T',
+}
+
+// maildirWriter stores each email as an individual file in a Maildir, named
+// after its Gmail ID so that exporting it again replaces the previous copy
+type maildirWriter struct {
+	dir string
+}
+
+func newMaildirWriter(options ExportOptions) (*maildirWriter, error) {
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported by the maildir format")
+	}
+	if options.OutputFile == StdoutOutput {
+		return nil, fmt.Errorf("the maildir format writes to a directory and cannot write to the standard output")
+	}
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(options.OutputFile, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+
+	return &maildirWriter{dir: options.OutputFile}, nil
+}
+
+func (w *maildirWriter) Write(msg *gmail.Message, email *Email) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	date := email.Date
+	if msg.InternalDate != 0 {
+		date = time.UnixMilli(msg.InternalDate)
+	}
+
+	// Maildir unique names only need to be unique within the folder, the
+	// Gmail ID already is
+	base := fmt.Sprintf("%d.%s.gmail", date.Unix(), msg.Id)
+	name := base + ":2," + maildirInfo(msg.LabelIds)
+
+	tmpPath := filepath.Join(w.dir, "tmp", base)
+	if err := os.WriteFile(tmpPath, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write maildir message: %w", err)
+	}
+
+	// Drop copies exported with other flags before their labels changed
+	previous, _ := filepath.Glob(filepath.Join(w.dir, "cur", base+":2,*"))
+	for _, path := range previous {
+		if filepath.Base(path) != name {
+			_ = os.Remove(path)
+		}
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(w.dir, "cur", name)); err != nil {
+		return fmt.Errorf("failed to deliver maildir message: %w", err)
+	}
+	return nil
+}
+
+func (w *maildirWriter) Flush() (int64, error) {
+	return 0, nil
+}
+
+func (w *maildirWriter) Close() error {
+	return nil
+}
+
+// maildirInfo returns the Maildir flags of a message in ASCII order
+func maildirInfo(labelIDs []string) string {
+	seen := true
+	var flags []byte
+	for _, labelID := range labelIDs {
+		if labelID == "UNREAD" {
+			seen = false
+		}
+		if flag, ok := maildirFlags[labelID]; ok {
+			flags = append(flags, flag)
+		}
+	}
+	if seen {
+		flags = append(flags, 'S')
+	}
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	return string(flags)
+}
+
+// emlWriter stores each email as <id>.eml in a directory tree named after
+// its labels. An email with several labels is hard linked into each of them.
+type emlWriter struct {
+	dir        string
+	labelNames map[string]string
+}
+
+func newEMLWriter(options ExportOptions) (*emlWriter, error) {
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported by the eml format")
+	}
+	if options.OutputFile == StdoutOutput {
+		return nil, fmt.Errorf("the eml format writes to a directory and cannot write to the standard output")
+	}
+
+	if err := os.MkdirAll(options.OutputFile, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	return &emlWriter{dir: options.OutputFile, labelNames: options.LabelNames}, nil
+}
+
+func (w *emlWriter) Write(msg *gmail.Message, email *Email) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	var first string
+	for _, dir := range w.labelDirs(msg.LabelIds) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create label directory: %w", err)
+		}
+
+		path := filepath.Join(dir, msg.Id+".eml")
+		if first != "" {
+			_ = os.Remove(path)
+			if err := os.Link(first, path); err == nil {
+				continue
+			}
+		}
+
+		if err := os.WriteFile(path, raw, 0644); err != nil {
+			return fmt.Errorf("failed to write eml file: %w", err)
+		}
+		if first == "" {
+			first = path
+		}
+	}
+
+	return nil
+}
+
+func (w *emlWriter) Flush() (int64, error) {
+	return 0, nil
+}
+
+func (w *emlWriter) Close() error {
+	return nil
+}
+
+// labelDirs returns the directories an email is stored in. Labels that only
+// describe the state of an email, such as UNREAD or categories, are not
+// folders; an email without any other label goes to "Unlabeled".
+func (w *emlWriter) labelDirs(labelIDs []string) []string {
+	var dirs []string
+	for _, labelID := range labelIDs {
+		if labelID == "UNREAD" || labelID == "STARRED" || labelID == "IMPORTANT" || strings.HasPrefix(labelID, "CATEGORY_") {
+			continue
+		}
+
+		name := labelID
+		if labelName, ok := w.labelNames[labelID]; ok && labelName != "" {
+			name = labelName
+		}
+		dirs = append(dirs, filepath.Join(w.dir, labelPath(name)))
+	}
+
+	if len(dirs) == 0 {
+		dirs = append(dirs, filepath.Join(w.dir, "Unlabeled"))
+	}
+	return dirs
+}
+
+// labelPath maps a label name to a relative path, nested labels such as
+// "Clients/Acme" becoming nested directories
+func labelPath(name string) string {
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		segment = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`<>:"\|?*`, r) || r < 0x20 {
+				return '_'
+			}
+			return r
+		}, strings.TrimSpace(segment))
+
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) == 0 {
+		return "_"
+	}
+	return filepath.Join(segments...)
+}
@@ -0,0 +1,217 @@
+package gmail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Supported groupings of the markdown-dir format
+const (
+	MarkdownGroupThread = "thread"
+	MarkdownGroupDate   = "date"
+)
+
+// maxSlugLength bounds the subject part of Markdown file names
+const maxSlugLength = 60
+
+// slugSeparators matches the runs of characters replaced by a dash in slugs
+var slugSeparators = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// markdownDirWriter writes each email as a Markdown file with YAML front
+// matter, in a folder per thread or per month
+type markdownDirWriter struct {
+	dir            string
+	group          string
+	labelNames     map[string]string
+	attachmentsDir string
+}
+
+func newMarkdownDirWriter(options ExportOptions) (*markdownDirWriter, error) {
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported by the markdown-dir format")
+	}
+	if options.OutputFile == StdoutOutput {
+		return nil, fmt.Errorf("the markdown-dir format writes to a directory and cannot write to the standard output")
+	}
+
+	group := options.MarkdownGroup
+	switch group {
+	case "":
+		group = MarkdownGroupThread
+	case MarkdownGroupThread, MarkdownGroupDate:
+	default:
+		return nil, fmt.Errorf("unsupported markdown grouping '%s'", group)
+	}
+
+	if err := os.MkdirAll(options.OutputFile, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	w := &markdownDirWriter{
+		dir:        options.OutputFile,
+		group:      group,
+		labelNames: options.LabelNames,
+	}
+	if options.IncludeAttachments {
+		w.attachmentsDir = options.AttachmentsDir
+	}
+	return w, nil
+}
+
+func (w *markdownDirWriter) Write(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+
+	dir := filepath.Join(w.dir, msg.ThreadId)
+	if w.group == MarkdownGroupDate {
+		dir = filepath.Join(w.dir, email.Date.Format("2006"), email.Date.Format("01"))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create markdown directory: %w", err)
+	}
+
+	name := email.Date.Format("2006-01-02")
+	if slug := slugify(record.Subject); slug != "" {
+		name += "-" + slug
+	}
+	path := filepath.Join(dir, name+"-"+msg.Id+".md")
+
+	labels := make([]string, 0, len(record.LabelIDs))
+	for _, labelID := range record.LabelIDs {
+		if labelName, ok := w.labelNames[labelID]; ok && labelName != "" {
+			labelID = labelName
+		}
+		labels = append(labels, labelID)
+	}
+
+	attachments := make([]string, 0, len(record.Attachments))
+	links := make([]string, 0, len(record.Attachments))
+	for _, att := range record.Attachments {
+		attachments = append(attachments, att.Filename)
+
+		link := escapeMarkdownText(att.Filename)
+		if w.attachmentsDir != "" {
+			target := filepath.Join(w.attachmentsDir, msg.Id, att.Filename)
+			if rel, err := relativePath(dir, target); err == nil {
+				link = "[" + link + "](" + rel + ")"
+			}
+		}
+		links = append(links, link)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	writeYAMLField(&buf, "id", record.ID)
+	writeYAMLField(&buf, "thread_id", record.ThreadID)
+	writeYAMLField(&buf, "date", record.Date)
+	writeYAMLField(&buf, "from", record.From)
+	writeYAMLField(&buf, "to", record.To)
+	if len(record.Cc) > 0 {
+		writeYAMLField(&buf, "cc", record.Cc)
+	}
+	writeYAMLField(&buf, "subject", record.Subject)
+	writeYAMLField(&buf, "labels", labels)
+	writeYAMLField(&buf, "attachments", attachments)
+	buf.WriteString("---\n\n")
+
+	buf.WriteString("# " + escapeMarkdownText(record.Subject) + "\n\n")
+
+	body := email.MarkdownBody
+	if body == "" {
+		body = email.Body
+	}
+	buf.WriteString(strings.TrimSpace(body) + "\n")
+
+	if len(links) > 0 {
+		buf.WriteString("\n## Attachments\n\n")
+		for _, link := range links {
+			buf.WriteString("- " + link + "\n")
+		}
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write markdown file: %w", err)
+	}
+	return nil
+}
+
+func (w *markdownDirWriter) Flush() (int64, error) {
+	return 0, nil
+}
+
+func (w *markdownDirWriter) Close() error {
+	return nil
+}
+
+// writeYAMLField writes a string or string list field of the front matter.
+// Values are written as JSON strings, which are valid YAML double-quoted
+// scalars.
+func writeYAMLField(buf *bytes.Buffer, key string, value any) {
+	switch v := value.(type) {
+	case string:
+		buf.WriteString(key + ": " + yamlString(v) + "\n")
+	case []string:
+		if len(v) == 0 {
+			buf.WriteString(key + ": []\n")
+			return
+		}
+		buf.WriteString(key + ":\n")
+		for _, item := range v {
+			buf.WriteString("  - " + yamlString(item) + "\n")
+		}
+	}
+}
+
+func yamlString(value string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// slugify turns a subject into a lowercase file name fragment
+func slugify(value string) string {
+	slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(value), "-"), "-")
+	if runes := []rune(slug); len(runes) > maxSlugLength {
+		slug = strings.TrimRight(string(runes[:maxSlugLength]), "-")
+	}
+	return slug
+}
+
+// relativePath returns the path of target relative to dir with each segment
+// URL-escaped, for use as a Markdown link or an HTML href
+func relativePath(dir, target string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, absTarget)
+	if err != nil {
+		return "", err
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// escapeMarkdownText escapes the characters that would start Markdown
+// syntax in a heading or link text
+func escapeMarkdownText(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`)
+	return replacer.Replace(value)
+}
@@ -0,0 +1,100 @@
+package gmail
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// mboxWriter writes emails to a single mbox file using the mboxrd variant,
+// where any line of a message starting with "From " preceded by zero or more
+// ">" is escaped with an additional ">"
+type mboxWriter struct {
+	out *streamOutput
+}
+
+func newMboxWriter(options ExportOptions) (*mboxWriter, error) {
+	out, err := newStreamOutput(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mboxWriter{out: out}, nil
+}
+
+func (w *mboxWriter) Write(msg *gmail.Message, email *Email) error {
+	raw, err := rawMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	date := email.Date
+	if msg.InternalDate != 0 {
+		date = time.UnixMilli(msg.InternalDate)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From %s %s\n", envelopeSender(raw), date.UTC().Format(time.ANSIC))
+	// Keep the Gmail labels and thread like Google Takeout does
+	if len(msg.LabelIds) > 0 {
+		fmt.Fprintf(&buf, "X-Gmail-Labels: %s\n", strings.Join(msg.LabelIds, ","))
+	}
+	if msg.ThreadId != "" {
+		fmt.Fprintf(&buf, "X-GM-THRID: %s\n", msg.ThreadId)
+	}
+
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	for line := range bytes.Lines(raw) {
+		if isFromLine(line) {
+			buf.WriteByte('>')
+		}
+		buf.Write(line)
+	}
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	if _, err := w.out.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write mbox message: %w", err)
+	}
+	return nil
+}
+
+func (w *mboxWriter) Flush() (int64, error) {
+	return w.out.Flush()
+}
+
+func (w *mboxWriter) Close() error {
+	return w.out.Close()
+}
+
+// isFromLine reports whether a line matches ^>*From and must be escaped
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// envelopeSender returns the address used in the mbox "From " separator
+// line, taken from the Return-Path or From header of the raw message
+func envelopeSender(raw []byte) string {
+	const unknownSender = "MAILER-DAEMON"
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return unknownSender
+	}
+
+	if returnPath := strings.Trim(strings.TrimSpace(msg.Header.Get("Return-Path")), "<>"); returnPath != "" && !strings.ContainsAny(returnPath, " \t") {
+		return returnPath
+	}
+
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil && from.Address != "" {
+		return from.Address
+	}
+
+	return unknownSender
+}
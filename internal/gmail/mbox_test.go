@@ -0,0 +1,125 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestIsFromLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"From alice@example.com Mon Jan  1 00:00:00 2024\n", true},
+		{"From here on\n", true},
+		{">From quoted\n", true},
+		{">>>From quoted again\n", true},
+		{"From\n", false},
+		{"from lowercase\n", false},
+		{" From indented\n", false},
+		{"> From spaced\n", false},
+		{"From:alice@example.com\n", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if got := isFromLine([]byte(tt.line)); got != tt.want {
+				t.Errorf("isFromLine(%q) = %t, want %t", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvelopeSender(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"return path", "Return-Path: <bounce@example.com>\r\nFrom: Alice <alice@example.com>\r\n\r\nHi", "bounce@example.com"},
+		{"from", "From: Alice <alice@example.com>\r\n\r\nHi", "alice@example.com"},
+		{"empty return path", "Return-Path: <>\r\nFrom: alice@example.com\r\n\r\nHi", "alice@example.com"},
+		{"invalid from", "From: not an address\r\n\r\nHi", "MAILER-DAEMON"},
+		{"no headers", "garbage", "MAILER-DAEMON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envelopeSender([]byte(tt.raw)); got != tt.want {
+				t.Errorf("envelopeSender() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMboxWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			"escaped from lines",
+			"From: alice@example.com\r\nSubject: Hi\r\n\r\nFrom the start\r\n>From quoted\r\nFrom: not a header\r\n",
+			"From alice@example.com Mon Jan  1 10:00:00 2024\nX-Gmail-Labels: INBOX,STARRED\nX-GM-THRID: thread\n" +
+				"From: alice@example.com\nSubject: Hi\n\n>From the start\n>>From quoted\nFrom: not a header\n\n",
+		},
+		{
+			"missing final newline",
+			"From: alice@example.com\r\n\r\nFrom me",
+			"From alice@example.com Mon Jan  1 10:00:00 2024\nX-Gmail-Labels: INBOX,STARRED\nX-GM-THRID: thread\n" +
+				"From: alice@example.com\n\n>From me\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "emails.mbox")
+			w, err := newMboxWriter(ExportOptions{OutputFile: path})
+			if err != nil {
+				t.Fatalf("newMboxWriter returned error: %v", err)
+			}
+
+			msg := &gmail.Message{
+				Id:           "id",
+				ThreadId:     "thread",
+				LabelIds:     []string{"INBOX", "STARRED"},
+				InternalDate: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC).UnixMilli(),
+				Raw:          base64.RawURLEncoding.EncodeToString([]byte(tt.raw)),
+			}
+			if err := w.Write(msg, &Email{}); err != nil {
+				t.Fatalf("Write returned error: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close returned error: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read mbox: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("mbox =\n%q\nwant\n%q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestMboxWriteWithoutRaw(t *testing.T) {
+	w, err := newMboxWriter(ExportOptions{OutputFile: filepath.Join(t.TempDir(), "emails.mbox")})
+	if err != nil {
+		t.Fatalf("newMboxWriter returned error: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.Write(&gmail.Message{Id: "id"}, &Email{}); !errors.Is(err, errSkipMessage) {
+		t.Errorf("Write() without raw message error = %v, want %v", err, errSkipMessage)
+	}
+}
@@ -0,0 +1,269 @@
+package gmail
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported output compressions
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// StdoutOutput is the output file path writing to the standard output
+const StdoutOutput = "-"
+
+// CompressionForPath returns the compression implied by the extension of an
+// output file path, CompressionNone when it has no compressed extension
+func CompressionForPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return CompressionGzip
+	case ".zst", ".zstd":
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// OpenInput opens an exported file for reading, decompressing it according
+// to its extension. The StdoutOutput path reads from the standard input.
+func OpenInput(path string) (io.ReadCloser, error) {
+	if path == StdoutOutput {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+
+	switch CompressionForPath(path) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read gzip input: %w", err)
+		}
+		return readCloser{Reader: gz, close: file.Close}, nil
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read zstd input: %w", err)
+		}
+		return readCloser{Reader: decoder, close: func() error {
+			decoder.Close()
+			return file.Close()
+		}}, nil
+	default:
+		return file, nil
+	}
+}
+
+// readCloser closes the file underlying a decompressing reader
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// compressor is a compressed stream that can be ended and restarted on the
+// same writer, gzip members and zstd frames both being concatenable
+type compressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// zstdCompressor adapts zstd.Encoder, whose Reset has no return value in
+// the compressor interface
+type zstdCompressor struct {
+	*zstd.Encoder
+}
+
+func (z zstdCompressor) Reset(w io.Writer) {
+	z.Encoder.Reset(w)
+}
+
+func newCompressor(compression string, w io.Writer) (compressor, error) {
+	switch compression {
+	case CompressionNone:
+		return nil, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return zstdCompressor{encoder}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression '%s'", compression)
+	}
+}
+
+// streamOutput is the output file shared by formats that write a single
+// stream of bytes. It optionally compresses the stream, and keeps track of
+// the output size so that checkpoints can record where a resumed export
+// must continue.
+type streamOutput struct {
+	file    *os.File
+	counter *countingWriter
+	buf     *bufio.Writer
+	comp    compressor
+	// dirty reports whether data was written since the last flush
+	dirty   bool
+	members int
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newStreamOutput(options ExportOptions) (*streamOutput, error) {
+	file, offset, err := openOutputFile(options)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &streamOutput{
+		file:    file,
+		counter: &countingWriter{w: file, n: offset},
+	}
+	out.buf = bufio.NewWriter(out.counter)
+	out.comp, err = newCompressor(options.Compression, out.buf)
+	if err != nil {
+		closeOutputFile(file)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (o *streamOutput) Write(p []byte) (int, error) {
+	o.dirty = true
+	if o.comp != nil {
+		return o.comp.Write(p)
+	}
+	return o.buf.Write(p)
+}
+
+// Flush writes buffered data to the output file and returns its size. A
+// compressed stream is ended at every flush and continued in a new member,
+// so that the file is valid when truncated to the returned size.
+func (o *streamOutput) Flush() (int64, error) {
+	if o.comp != nil && o.dirty {
+		if err := o.comp.Close(); err != nil {
+			return 0, fmt.Errorf("failed to compress output: %w", err)
+		}
+		o.comp.Reset(o.buf)
+		o.members++
+	}
+	o.dirty = false
+
+	if err := o.buf.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to flush output file: %w", err)
+	}
+	return o.counter.n, nil
+}
+
+func (o *streamOutput) Close() error {
+	// An empty compressed output still needs one member to be readable
+	if o.comp != nil && o.members == 0 {
+		o.dirty = true
+	}
+	if _, err := o.Flush(); err != nil {
+		closeOutputFile(o.file)
+		return err
+	}
+	return closeOutputFile(o.file)
+}
+
+// openOutputFile creates the output file, or reopens it for appending when
+// appending or resuming. A resumed file is first truncated to the size
+// recorded in the checkpoint to drop data written after the last save. It
+// returns the file along with the offset new data is written at. The
+// StdoutOutput path returns the standard output, which cannot be resumed.
+func openOutputFile(options ExportOptions) (*os.File, int64, error) {
+	if options.OutputFile == StdoutOutput {
+		if options.Resume {
+			return nil, 0, fmt.Errorf("an export to the standard output cannot be resumed")
+		}
+		return os.Stdout, 0, nil
+	}
+
+	outputDir := filepath.Dir(options.OutputFile)
+	if outputDir != "." && outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return nil, 0, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	if options.Append {
+		file, err := os.OpenFile(options.OutputFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to open output file: %w", err)
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, 0, fmt.Errorf("failed to stat output file: %w", err)
+		}
+		return file, info.Size(), nil
+	}
+
+	if !options.Resume || options.Checkpoint == nil {
+		file, err := os.Create(options.OutputFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create output file: %w", err)
+		}
+		return file, 0, nil
+	}
+
+	file, err := os.OpenFile(options.OutputFile, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open output file: %w", err)
+	}
+
+	offset := options.Checkpoint.OutputSize
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to seek output file: %w", err)
+	}
+
+	return file, offset, nil
+}
+
+// closeOutputFile closes a file returned by openOutputFile, leaving the
+// standard output open
+func closeOutputFile(file *os.File) error {
+	if file == os.Stdout {
+		return nil
+	}
+	return file.Close()
+}
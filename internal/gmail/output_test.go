@@ -0,0 +1,159 @@
+package gmail
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readOutput reads back an output file, decompressing it like an import
+func readOutput(t *testing.T, path string) string {
+	t.Helper()
+	input, err := OpenInput(path)
+	if err != nil {
+		t.Fatalf("OpenInput(%s) returned error: %v", path, err)
+	}
+	defer input.Close()
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// writeOutput writes each chunk to a new stream output, flushing after
+// every flushed chunk, and returns the size of the last flush
+func writeOutput(t *testing.T, options ExportOptions, chunks []string, flushed int) int64 {
+	t.Helper()
+	out, err := newStreamOutput(options)
+	if err != nil {
+		t.Fatalf("newStreamOutput returned error: %v", err)
+	}
+
+	var size int64
+	for i, chunk := range chunks {
+		if _, err := out.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		if i < flushed {
+			if size, err = out.Flush(); err != nil {
+				t.Fatalf("Flush returned error: %v", err)
+			}
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	return size
+}
+
+func TestStreamOutputResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		compression string
+	}{
+		{"plain", "emails.jsonl", CompressionNone},
+		{"gzip", "emails.jsonl.gz", CompressionGzip},
+		{"zstd", "emails.jsonl.zst", CompressionZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.filename)
+			options := ExportOptions{OutputFile: path, Compression: tt.compression}
+
+			// The first run is interrupted after its second checkpoint, with
+			// a third record written after it
+			size := writeOutput(t, options, []string{"one\n", "two\n", "lost\n"}, 2)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat output: %v", err)
+			}
+			if size >= info.Size() {
+				t.Fatalf("checkpoint size %d covers the whole %d bytes output", size, info.Size())
+			}
+
+			// Every flush ends a compressed member, so the output is readable
+			// when truncated to the checkpoint
+			if err := os.Truncate(path, size); err != nil {
+				t.Fatalf("failed to truncate output: %v", err)
+			}
+			if got := readOutput(t, path); got != "one\ntwo\n" {
+				t.Errorf("output truncated to the checkpoint = %q, want %q", got, "one\ntwo\n")
+			}
+
+			writeOutput(t, options, []string{"one\n", "two\n", "lost\n"}, 2)
+			resumed := options
+			resumed.Resume = true
+			resumed.Checkpoint = &Checkpoint{OutputSize: size}
+			writeOutput(t, resumed, []string{"three\n"}, 1)
+
+			if got, want := readOutput(t, path), "one\ntwo\nthree\n"; got != want {
+				t.Errorf("resumed output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStreamOutputAppend(t *testing.T) {
+	for _, filename := range []string{"emails.jsonl", "emails.jsonl.gz", "emails.jsonl.zst"} {
+		t.Run(filename, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), filename)
+			options := ExportOptions{OutputFile: path, Compression: CompressionForPath(path)}
+
+			first := writeOutput(t, options, []string{"one\n"}, 1)
+			options.Append = true
+			second := writeOutput(t, options, []string{"two\n"}, 1)
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat output: %v", err)
+			}
+			if second <= first || second != info.Size() {
+				t.Errorf("flush sizes %d then %d, want the appended file size %d", first, second, info.Size())
+			}
+			if got := readOutput(t, path); got != "one\ntwo\n" {
+				t.Errorf("appended output = %q, want %q", got, "one\ntwo\n")
+			}
+		})
+	}
+}
+
+func TestStreamOutputEmptyCompressed(t *testing.T) {
+	for _, filename := range []string{"emails.jsonl.gz", "emails.jsonl.zst"} {
+		t.Run(filename, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), filename)
+			writeOutput(t, ExportOptions{OutputFile: path, Compression: CompressionForPath(path)}, nil, 0)
+
+			if got := readOutput(t, path); got != "" {
+				t.Errorf("empty output = %q", got)
+			}
+		})
+	}
+}
+
+func TestOpenOutputFileStdoutResume(t *testing.T) {
+	if _, _, err := openOutputFile(ExportOptions{OutputFile: StdoutOutput, Resume: true}); err == nil {
+		t.Error("openOutputFile resumed the standard output")
+	}
+}
+
+func TestCompressionForPath(t *testing.T) {
+	tests := map[string]string{
+		"emails.jsonl":     CompressionNone,
+		"emails.jsonl.gz":  CompressionGzip,
+		"emails.mbox.GZ":   CompressionGzip,
+		"emails.jsonl.zst": CompressionZstd,
+		"emails.csv.zstd":  CompressionZstd,
+		"-":                CompressionNone,
+	}
+
+	for path, want := range tests {
+		if got := CompressionForPath(path); got != want {
+			t.Errorf("CompressionForPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package gmail
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"google.golang.org/api/gmail/v1"
+)
+
+// parquetRowGroupSize is the number of emails buffered in memory before a
+// row group is written to the Parquet file
+const parquetRowGroupSize = 1000
+
+// parquetEmail is the Parquet row of an email, mirroring JSONLEmail with
+// typed columns
+type parquetEmail struct {
+	ID          string              `parquet:"id"`
+	ThreadID    string              `parquet:"thread_id"`
+	LabelIDs    []string            `parquet:"label_ids,list"`
+	Subject     string              `parquet:"subject"`
+	From        string              `parquet:"from"`
+	To          []string            `parquet:"to,list"`
+	Cc          []string            `parquet:"cc,list"`
+	Bcc         []string            `parquet:"bcc,list"`
+	Date        time.Time           `parquet:"date,timestamp(millisecond)"`
+	DateSource  string              `parquet:"date_source"`
+	Body        parquetBody         `parquet:"body"`
+	Attachments []parquetAttachment `parquet:"attachments,list"`
+	Headers     map[string]string   `parquet:"headers"`
+}
+
+type parquetBody struct {
+	Text        string `parquet:"text"`
+	HTML        string `parquet:"html"`
+	Markdown    string `parquet:"markdown"`
+	TextCharset string `parquet:"text_charset"`
+	HTMLCharset string `parquet:"html_charset"`
+}
+
+type parquetAttachment struct {
+	ID              string `parquet:"id"`
+	Filename        string `parquet:"filename"`
+	EncodedFilename string `parquet:"encoded_filename"`
+	MimeType        string `parquet:"mime_type"`
+	Size            int64  `parquet:"size"`
+	PartID          string `parquet:"part_id"`
+	Role            string `parquet:"role"`
+	ContentID       string `parquet:"content_id"`
+}
+
+// parquetWriter writes emails to a Parquet file. The file footer is only
+// written on close, so Parquet outputs cannot be appended to or resumed.
+type parquetWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	parquet *parquet.GenericWriter[parquetEmail]
+	// buffered is the number of rows of the current row group
+	buffered int
+}
+
+func newParquetWriter(options ExportOptions) (*parquetWriter, error) {
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported by the parquet format, columns are compressed internally")
+	}
+	if options.Resume || options.Append {
+		return nil, fmt.Errorf("parquet files cannot be resumed or appended to")
+	}
+
+	file, _, err := openOutputFile(options)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(file)
+	return &parquetWriter{
+		file:    file,
+		buf:     buf,
+		parquet: parquet.NewGenericWriter[parquetEmail](buf, parquet.Compression(&parquet.Snappy)),
+	}, nil
+}
+
+func (w *parquetWriter) Write(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+
+	row := parquetEmail{
+		ID:         record.ID,
+		ThreadID:   record.ThreadID,
+		LabelIDs:   record.LabelIDs,
+		Subject:    record.Subject,
+		From:       record.From,
+		To:         record.To,
+		Cc:         record.Cc,
+		Bcc:        record.Bcc,
+		Date:       email.Date,
+		DateSource: email.DateSource,
+		Body: parquetBody{
+			Text:        record.Body.Text,
+			HTML:        record.Body.HTML,
+			Markdown:    record.Body.Markdown,
+			TextCharset: record.Body.TextCharset,
+			HTMLCharset: record.Body.HTMLCharset,
+		},
+		Headers: record.Headers,
+	}
+	for _, att := range record.Attachments {
+		row.Attachments = append(row.Attachments, parquetAttachment(att))
+	}
+
+	if _, err := w.parquet.Write([]parquetEmail{row}); err != nil {
+		return fmt.Errorf("failed to write parquet row: %w", err)
+	}
+
+	w.buffered++
+	if w.buffered >= parquetRowGroupSize {
+		if err := w.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush does nothing, row groups are written every parquetRowGroupSize emails
+// and a Parquet file is only readable once closed, so there is no size to
+// record in checkpoints
+func (w *parquetWriter) Flush() (int64, error) {
+	return 0, nil
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.parquet.Close(); err != nil {
+		closeOutputFile(w.file)
+		return fmt.Errorf("failed to write parquet footer: %w", err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		closeOutputFile(w.file)
+		return fmt.Errorf("failed to flush output file: %w", err)
+	}
+	return closeOutputFile(w.file)
+}
+
+func (w *parquetWriter) flushRowGroup() error {
+	if err := w.parquet.Flush(); err != nil {
+		return fmt.Errorf("failed to write parquet row group: %w", err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush output file: %w", err)
+	}
+	w.buffered = 0
+	return nil
+}
@@ -16,8 +16,18 @@ import (
 type Attachment struct {
 	ID       string
 	Filename string
-	MimeType string
-	Size     int64
+	// EncodedFilename is the filename before RFC 2047 or RFC 2231 decoding,
+	// when it differs from Filename
+	EncodedFilename string
+	MimeType        string
+	Size            int64
+	PartID          string
+	// Role is PartRoleAttachment or PartRoleInline
+	Role      string
+	ContentID string
+	// Data is the base64url content of small parts, which Gmail returns
+	// inline instead of giving them an attachment ID
+	Data string
 }
 
 type Email struct {
@@ -29,63 +39,155 @@ type Email struct {
 	Body         string
 	HTMLBody     string
 	MarkdownBody string
-	Labels       []string
-	Attachments  []Attachment
+	// DateSource tells whether Date comes from the Date header or from the
+	// date Gmail received the email
+	DateSource string
+	// TextCharset and HTMLCharset are the source charsets Body and
+	// HTMLBody were decoded from
+	TextCharset string
+	HTMLCharset string
+	Labels      []string
+	Attachments []Attachment
+	// EmbeddedMessages are the emails forwarded as message/rfc822 parts
+	EmbeddedMessages []*Email
+	// PartID and Headers are only set on embedded messages, whose headers
+	// are not those of a Gmail message
+	PartID  string
+	Headers []*gmail.MessagePartHeader
 }
 
+// extractContent walks the MIME tree of a message, decoding the first text
+// and HTML body parts and collecting the attachments and inline parts at
+// any depth
 func extractContent(payload *gmail.MessagePart, email *Email) {
-	if payload.Body != nil && payload.Body.Data != "" {
-		if payload.MimeType == "text/plain" {
-			decoded, err := base64.URLEncoding.DecodeString(payload.Body.Data)
+	walkContent(payload, email, false)
+	finishContent(email)
+}
+
+// finishContent names the attachments uniquely and sets the placeholder
+// body of emails without a text part once their parts are walked
+func finishContent(email *Email) {
+	uniqueFilenames(email.Attachments)
+
+	if email.Body == "" && email.HTMLBody != "" {
+		email.Body = htmlOnlyBody
+	}
+}
+
+// walkContent extracts the content of a part and its children. The bodies
+// of forwarded messages are not bodies of the email, only their attachments
+// are collected.
+func walkContent(part *gmail.MessagePart, email *Email, forwarded bool) {
+	switch role := partRole(part); role {
+	case PartRoleBody:
+		if forwarded || part.Body == nil || part.Body.Data == "" {
+			break
+		}
+		if part.MimeType == "text/plain" && email.Body == "" {
+			decoded, err := decodeBase64URL(part.Body.Data)
 			if err != nil {
-				slog.Warn("Failed to decode plain text body", "error", err)
+				slog.Warn("Failed to decode plain text part", "part_id", part.PartId, "error", err)
 			} else {
-				email.Body = string(decoded)
+				email.Body, email.TextCharset = decodeCharset(decoded, part)
 			}
-		} else if payload.MimeType == "text/html" {
-			decoded, err := base64.URLEncoding.DecodeString(payload.Body.Data)
+		} else if part.MimeType == "text/html" && email.HTMLBody == "" {
+			decoded, err := decodeBase64URL(part.Body.Data)
 			if err != nil {
-				slog.Warn("Failed to decode HTML body", "error", err)
+				slog.Warn("Failed to decode HTML part", "part_id", part.PartId, "error", err)
 			} else {
-				email.HTMLBody = string(decoded)
+				email.HTMLBody, email.HTMLCharset = decodeCharset(decoded, part)
 			}
 		}
+	case PartRoleInline, PartRoleAttachment:
+		filename, encoded := attachmentFilename(part)
+		attachment := Attachment{
+			Filename:        filename,
+			EncodedFilename: encoded,
+			MimeType:        part.MimeType,
+			PartID:          part.PartId,
+			Role:            role,
+			ContentID:       partContentID(part),
+		}
+		if part.Body != nil {
+			attachment.ID = part.Body.AttachmentId
+			attachment.Size = part.Body.Size
+			if attachment.ID == "" {
+				attachment.Data = part.Body.Data
+			}
+		}
+		email.Attachments = append(email.Attachments, attachment)
 	}
 
-	for _, part := range payload.Parts {
-		if part.Filename != "" {
-			attachment := Attachment{
-				ID:       part.Body.AttachmentId,
-				Filename: part.Filename,
-				MimeType: part.MimeType,
-				Size:     part.Body.Size,
-			}
-			email.Attachments = append(email.Attachments, attachment)
-			continue
+	// Forwarded messages are parsed whatever their role, those attached
+	// with a filename are also listed as attachments above. Messages they
+	// forward in turn are parsed into their own embedded messages.
+	if part.MimeType == "message/rfc822" && !forwarded {
+		if embedded := parseEmbedded(part); embedded != nil {
+			email.EmbeddedMessages = append(email.EmbeddedMessages, embedded)
 		}
+	}
 
-		if part.MimeType == "text/plain" && part.Body != nil && part.Body.Data != "" {
-			decoded, err := base64.URLEncoding.DecodeString(part.Body.Data)
-			if err != nil {
-				slog.Warn("Failed to decode plain text part", "error", err)
-			} else if email.Body == "" {
-				email.Body = string(decoded)
-			}
-		} else if part.MimeType == "text/html" && part.Body != nil && part.Body.Data != "" {
-			decoded, err := base64.URLEncoding.DecodeString(part.Body.Data)
-			if err != nil {
-				slog.Warn("Failed to decode HTML part", "error", err)
-			} else if email.HTMLBody == "" {
-				email.HTMLBody = string(decoded)
-			}
-		} else if strings.HasPrefix(part.MimeType, "multipart/") {
-			extractContent(part, email)
+	for _, child := range part.Parts {
+		walkContent(child, email, forwarded || part.MimeType == "message/rfc822")
+	}
+}
+
+// parseEmbedded parses a forwarded message/rfc822 part into an email. Gmail
+// returns the content of the forwarded email as the single child of the
+// part, its headers included, and nothing when the forwarded email could
+// not be parsed.
+func parseEmbedded(part *gmail.MessagePart) *Email {
+	if len(part.Parts) == 0 {
+		slog.Debug("Forwarded message has no content", "part_id", part.PartId)
+		return nil
+	}
+	root := part
+	if len(part.Parts) == 1 {
+		root = part.Parts[0]
+	}
+
+	email := &Email{
+		From:        decodeAddresses(partHeader(root, "From")),
+		To:          decodeAddresses(partHeader(root, "To")),
+		Subject:     decodeHeader(partHeader(root, "Subject")),
+		Attachments: []Attachment{},
+		PartID:      part.PartId,
+		Headers:     root.Headers,
+	}
+	if value := partHeader(root, "Date"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			slog.Debug("Failed to parse date of forwarded message", "part_id", part.PartId, "date", value, "error", err)
+		} else {
+			email.Date, email.DateSource = date, DateSourceHeader
 		}
 	}
 
-	if email.Body == "" && email.HTMLBody != "" {
-		email.Body = "[Email contains HTML content only]"
+	if root != part {
+		extractContent(root, email)
+		return email
 	}
+
+	// Walking the forwarded part itself would embed it again, walk its
+	// children as the content of the forwarded email instead
+	for _, child := range part.Parts {
+		walkContent(child, email, false)
+	}
+	finishContent(email)
+	return email
+}
+
+// htmlOnlyBody is the plain text body of an email without a text part
+const htmlOnlyBody = "[Email contains HTML content only]"
+
+// decodeBase64URL decodes base64url data as returned by the Gmail API, with
+// or without padding
+func decodeBase64URL(data string) ([]byte, error) {
+	decoded, err := base64.URLEncoding.DecodeString(data)
+	if err != nil {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+	}
+	return decoded, nil
 }
 
 func (e *Email) String() string {
@@ -105,9 +207,16 @@ func (e *Email) String() string {
 		}
 	}
 
+	if len(e.EmbeddedMessages) > 0 {
+		sb.WriteString(fmt.Sprintf("\nEmbedded messages (%d):\n", len(e.EmbeddedMessages)))
+		for _, embedded := range e.EmbeddedMessages {
+			sb.WriteString(fmt.Sprintf("  - %s (from %s)\n", embedded.Subject, embedded.From))
+		}
+	}
+
 	sb.WriteString(fmt.Sprintf("\nBody:\n%s\n", e.Body))
 
-	if e.HTMLBody != "" && e.Body != "[Email contains HTML content only]" {
+	if e.HTMLBody != "" && e.Body != htmlOnlyBody {
 		sb.WriteString("\n[Note: Email also contains HTML version]\n")
 	}
 
@@ -137,5 +246,11 @@ func convertToMarkdown(email *Email, removeImg, removeLink bool) error {
 	} else if email.Body != "" {
 		email.MarkdownBody = email.Body
 	}
+
+	for _, embedded := range email.EmbeddedMessages {
+		if err := convertToMarkdown(embedded, removeImg, removeLink); err != nil {
+			slog.Warn("Failed to convert forwarded message to markdown", "part_id", embedded.PartID, "error", err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,174 @@
+package gmail
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Roles of the MIME parts of an email. Multipart containers and forwarded
+// messages have no role, their children do.
+const (
+	// PartRoleBody is a text or HTML part holding the body of the email
+	PartRoleBody = "body"
+	// PartRoleInline is a part displayed within the body, such as an image
+	// referenced by its Content-ID
+	PartRoleInline = "inline"
+	// PartRoleAttachment is a file attached to the email
+	PartRoleAttachment = "attachment"
+)
+
+// partExtensions are the extensions given to parts without a filename,
+// for the types mime.ExtensionsByType has no obvious choice for
+var partExtensions = map[string]string{
+	"image/jpeg":     ".jpg",
+	"image/png":      ".png",
+	"image/gif":      ".gif",
+	"text/calendar":  ".ics",
+	"text/plain":     ".txt",
+	"text/html":      ".html",
+	"message/rfc822": ".eml",
+}
+
+// MIMEPart describes one part of the MIME structure of an email
+type MIMEPart struct {
+	PartID      string `json:"part_id"`
+	ContentType string `json:"content_type"`
+	// Role is body, inline or attachment, see partRole
+	Role    string `json:"role,omitempty"`
+	Charset string `json:"charset,omitempty"`
+	// Disposition is the Content-Disposition type, inline or attachment
+	Disposition  string `json:"disposition,omitempty"`
+	ContentID    string `json:"content_id,omitempty"`
+	Size         int64  `json:"size"`
+	Filename     string `json:"filename,omitempty"`
+	AttachmentID string `json:"attachment_id,omitempty"`
+	// Path lists the content types of the enclosing parts, from the root
+	// of the message down to this part
+	Path []string `json:"path"`
+}
+
+// messageParts flattens the MIME tree of a message into a depth-first list
+// of parts, the root part first
+func messageParts(payload *gmail.MessagePart) []MIMEPart {
+	var parts []MIMEPart
+	var walk func(part *gmail.MessagePart, path []string)
+	walk = func(part *gmail.MessagePart, path []string) {
+		path = append(path[:len(path):len(path)], part.MimeType)
+
+		info := MIMEPart{
+			PartID:      part.PartId,
+			ContentType: part.MimeType,
+			Role:        partRole(part),
+			Path:        path,
+		}
+		info.Filename, _ = partFilename(part)
+		if part.Body != nil {
+			info.Size = part.Body.Size
+			info.AttachmentID = part.Body.AttachmentId
+		}
+		if _, params, err := mime.ParseMediaType(partHeader(part, "Content-Type")); err == nil {
+			info.Charset = strings.ToLower(params["charset"])
+		}
+		info.Disposition = partDisposition(part)
+		info.ContentID = partContentID(part)
+
+		parts = append(parts, info)
+		for _, child := range part.Parts {
+			walk(child, path)
+		}
+	}
+
+	if payload != nil {
+		walk(payload, nil)
+	}
+	return parts
+}
+
+// partRole classifies a MIME part as body, inline or attachment, or returns
+// an empty role for containers and empty parts. An attachment disposition
+// always makes an attachment, then text and HTML parts without a filename
+// are bodies, and parts with an inline disposition or a Content-ID are
+// displayed inline.
+func partRole(part *gmail.MessagePart) string {
+	disposition := partDisposition(part)
+	filename, _ := partFilename(part)
+
+	switch {
+	case strings.HasPrefix(part.MimeType, "multipart/"):
+		return ""
+	case disposition == "attachment":
+		return PartRoleAttachment
+	case (part.MimeType == "text/plain" || part.MimeType == "text/html") && filename == "":
+		return PartRoleBody
+	case part.MimeType == "message/rfc822" && filename == "":
+		// Forwarded messages without a filename are walked like containers
+		return ""
+	case disposition == "inline" || partContentID(part) != "":
+		return PartRoleInline
+	case filename != "" || (part.Body != nil && (part.Body.AttachmentId != "" || part.Body.Size > 0)):
+		return PartRoleAttachment
+	}
+	return ""
+}
+
+// partDisposition returns the lowercased Content-Disposition type of a part
+func partDisposition(part *gmail.MessagePart) string {
+	disposition, _, err := mime.ParseMediaType(partHeader(part, "Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return disposition
+}
+
+// partContentID returns the Content-ID of a part without its angle brackets
+func partContentID(part *gmail.MessagePart) string {
+	return strings.Trim(strings.TrimSpace(partHeader(part, "Content-ID")), "<>")
+}
+
+// attachmentFilename returns the decoded filename of a part, or names it
+// after its part ID with an extension guessed from its type
+func attachmentFilename(part *gmail.MessagePart) (string, string) {
+	if filename, encoded := partFilename(part); filename != "" {
+		return filename, encoded
+	}
+
+	extension, ok := partExtensions[part.MimeType]
+	if !ok {
+		if extensions, err := mime.ExtensionsByType(part.MimeType); err == nil && len(extensions) > 0 {
+			extension = extensions[0]
+		}
+	}
+	return "part-" + part.PartId + extension, ""
+}
+
+// uniqueFilenames renames attachments sharing a filename with an earlier
+// attachment of the same email, compared case-insensitively, by adding a -N
+// suffix before the extension so that they are downloaded to distinct files
+func uniqueFilenames(attachments []Attachment) {
+	used := make(map[string]bool, len(attachments))
+	for i := range attachments {
+		filename := attachments[i].Filename
+		extension := filepath.Ext(filename)
+		stem := strings.TrimSuffix(filename, extension)
+		for n := 2; used[strings.ToLower(filename)]; n++ {
+			filename = fmt.Sprintf("%s-%d%s", stem, n, extension)
+		}
+		used[strings.ToLower(filename)] = true
+		attachments[i].Filename = filename
+	}
+}
+
+// partHeader returns the value of a header of a MIME part, matching its name
+// case-insensitively
+func partHeader(part *gmail.MessagePart, name string) string {
+	for _, header := range part.Headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
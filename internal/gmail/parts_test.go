@@ -0,0 +1,278 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// testPart builds a MIME part with the given headers, given as name and
+// value pairs
+func testPart(partID, mimeType string, headers ...string) *gmail.MessagePart {
+	part := &gmail.MessagePart{PartId: partID, MimeType: mimeType, Body: &gmail.MessagePartBody{}}
+	for i := 0; i+1 < len(headers); i += 2 {
+		part.Headers = append(part.Headers, &gmail.MessagePartHeader{Name: headers[i], Value: headers[i+1]})
+	}
+	return part
+}
+
+// withData sets the body of a part, encoded without padding like some
+// Gmail responses
+func withData(part *gmail.MessagePart, data string) *gmail.MessagePart {
+	part.Body.Data = base64.RawURLEncoding.EncodeToString([]byte(data))
+	part.Body.Size = int64(len(data))
+	return part
+}
+
+// withFilename sets the filename Gmail extracts from the part headers
+func withFilename(part *gmail.MessagePart, filename string) *gmail.MessagePart {
+	part.Filename = filename
+	return part
+}
+
+// withChildren sets the children of a part
+func withChildren(part *gmail.MessagePart, children ...*gmail.MessagePart) *gmail.MessagePart {
+	part.Parts = children
+	return part
+}
+
+func TestPartRole(t *testing.T) {
+	tests := []struct {
+		name string
+		part *gmail.MessagePart
+		want string
+	}{
+		{"multipart", testPart("", "multipart/mixed"), ""},
+		{"plain text", testPart("0", "text/plain"), PartRoleBody},
+		{"html", testPart("1", "text/html"), PartRoleBody},
+		{"text with filename", withFilename(testPart("1", "text/plain", "Content-Disposition", "inline"), "notes.txt"), PartRoleInline},
+		{"text attachment", testPart("1", "text/html", "Content-Disposition", "attachment"), PartRoleAttachment},
+		{"attachment disposition with content id", testPart("1", "image/png", "Content-Disposition", "attachment", "Content-ID", "<logo>"), PartRoleAttachment},
+		{"content id only", testPart("1", "image/png", "Content-ID", "<logo>"), PartRoleInline},
+		{"inline disposition", testPart("1", "image/png", "Content-Disposition", "inline"), PartRoleInline},
+		{"filename only", withFilename(testPart("1", "application/pdf"), "a.pdf"), PartRoleAttachment},
+		{"attachment id only", &gmail.MessagePart{PartId: "1", MimeType: "application/pdf", Body: &gmail.MessagePartBody{AttachmentId: "att"}}, PartRoleAttachment},
+		{"empty part", testPart("1", "application/octet-stream"), ""},
+		{"forwarded message", testPart("1", "message/rfc822"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partRole(tt.part); got != tt.want {
+				t.Errorf("partRole() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractContent(t *testing.T) {
+	// multipart/mixed
+	// ├── multipart/related
+	// │   ├── multipart/alternative
+	// │   │   ├── text/plain
+	// │   │   └── text/html
+	// │   └── image/png, Content-ID only
+	// ├── application/pdf, report.pdf
+	// └── multipart/mixed
+	//     ├── application/pdf, REPORT.pdf
+	//     └── image/png, inline without filename
+	payload := withChildren(testPart("", "multipart/mixed"),
+		withChildren(testPart("0", "multipart/related"),
+			withChildren(testPart("0.0", "multipart/alternative"),
+				withData(testPart("0.0.0", "text/plain"), "Hello"),
+				withData(testPart("0.0.1", "text/html"), `<p>Hello <img src="cid:logo"></p>`),
+			),
+			withData(testPart("0.1", "image/png", "Content-ID", "<logo>"), "png"),
+		),
+		withData(withFilename(testPart("1", "application/pdf", "Content-Disposition", "attachment"), "report.pdf"), "pdf"),
+		withChildren(testPart("2", "multipart/mixed"),
+			withData(withFilename(testPart("2.0", "application/pdf", "Content-Disposition", "attachment"), "REPORT.pdf"), "pdf"),
+			withData(testPart("2.1", "image/png", "Content-Disposition", "inline"), "png"),
+		),
+	)
+
+	email := &Email{}
+	extractContent(payload, email)
+
+	if email.Body != "Hello" {
+		t.Errorf("Body = %q, want %q", email.Body, "Hello")
+	}
+	if want := `<p>Hello <img src="cid:logo"></p>`; email.HTMLBody != want {
+		t.Errorf("HTMLBody = %q, want %q", email.HTMLBody, want)
+	}
+
+	want := []Attachment{
+		{Filename: "part-0.1.png", MimeType: "image/png", Size: 3, PartID: "0.1", Role: PartRoleInline, ContentID: "logo"},
+		{Filename: "report.pdf", MimeType: "application/pdf", Size: 3, PartID: "1", Role: PartRoleAttachment},
+		{Filename: "REPORT-2.pdf", MimeType: "application/pdf", Size: 3, PartID: "2.0", Role: PartRoleAttachment},
+		{Filename: "part-2.1.png", MimeType: "image/png", Size: 3, PartID: "2.1", Role: PartRoleInline},
+	}
+	if len(email.Attachments) != len(want) {
+		t.Fatalf("got %d attachments, want %d: %+v", len(email.Attachments), len(want), email.Attachments)
+	}
+	for i, att := range email.Attachments {
+		att.Data = ""
+		if att != want[i] {
+			t.Errorf("attachment %d = %+v, want %+v", i, att, want[i])
+		}
+	}
+}
+
+func TestExtractContentHTMLOnly(t *testing.T) {
+	email := &Email{}
+	extractContent(withData(testPart("", "text/html"), "<p>Hi</p>"), email)
+
+	if email.Body != htmlOnlyBody || email.HTMLBody != "<p>Hi</p>" {
+		t.Errorf("Body = %q, HTMLBody = %q, want %q, %q", email.Body, email.HTMLBody, htmlOnlyBody, "<p>Hi</p>")
+	}
+}
+
+func TestUniqueFilenames(t *testing.T) {
+	tests := []struct {
+		name      string
+		filenames []string
+		want      []string
+	}{
+		{"distinct", []string{"a.pdf", "b.pdf"}, []string{"a.pdf", "b.pdf"}},
+		{"duplicates", []string{"a.pdf", "a.pdf", "a.pdf"}, []string{"a.pdf", "a-2.pdf", "a-3.pdf"}},
+		{"case-insensitive", []string{"scan.PDF", "Scan.pdf"}, []string{"scan.PDF", "Scan-2.pdf"}},
+		{"suffix already taken", []string{"a.pdf", "a-2.pdf", "a.pdf"}, []string{"a.pdf", "a-2.pdf", "a-3.pdf"}},
+		{"without extension", []string{"README", "README"}, []string{"README", "README-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachments := make([]Attachment, len(tt.filenames))
+			for i, filename := range tt.filenames {
+				attachments[i].Filename = filename
+			}
+			uniqueFilenames(attachments)
+
+			var got []string
+			for _, att := range attachments {
+				got = append(got, att.Filename)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("uniqueFilenames(%q) = %q, want %q", tt.filenames, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractContentForwarded(t *testing.T) {
+	// forwarded builds a forwarded message/rfc822 part, which Gmail returns
+	// with the forwarded email as its single child
+	forwarded := func(part *gmail.MessagePart, subject string) *gmail.MessagePart {
+		id := part.PartId
+		return withChildren(part,
+			withChildren(testPart(id+".0", "multipart/mixed", "Subject", subject, "From", "Vendor <billing@vendor.com>"),
+				withData(testPart(id+".0.0", "text/plain"), "Forwarded "+subject),
+				withData(withFilename(testPart(id+".0.1", "application/pdf", "Content-Disposition", "attachment"), subject+".pdf"), "pdf"),
+			),
+		)
+	}
+
+	tests := []struct {
+		name            string
+		part            *gmail.MessagePart
+		wantAttachments []string
+	}{
+		{"without disposition", forwarded(testPart("1", "message/rfc822"), "invoice"), []string{"invoice.pdf"}},
+		{"attachment disposition", forwarded(testPart("1", "message/rfc822", "Content-Disposition", "attachment"), "invoice"), []string{"part-1.eml", "invoice.pdf"}},
+		{"filename", forwarded(withFilename(testPart("1", "message/rfc822", "Content-Disposition", "attachment"), "fwd.eml"), "invoice"), []string{"fwd.eml", "invoice.pdf"}},
+		{"inline with filename", forwarded(withFilename(testPart("1", "message/rfc822", "Content-Disposition", "inline"), "fwd.eml"), "invoice"), []string{"fwd.eml", "invoice.pdf"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := withChildren(testPart("", "multipart/mixed"),
+				withData(testPart("0", "text/plain"), "See below"),
+				tt.part,
+			)
+
+			email := &Email{}
+			extractContent(payload, email)
+
+			if email.Body != "See below" {
+				t.Errorf("Body = %q, want the body of the forwarding email", email.Body)
+			}
+			var filenames []string
+			for _, att := range email.Attachments {
+				filenames = append(filenames, att.Filename)
+			}
+			if !slices.Equal(filenames, tt.wantAttachments) {
+				t.Errorf("attachments = %q, want %q", filenames, tt.wantAttachments)
+			}
+
+			if len(email.EmbeddedMessages) != 1 {
+				t.Fatalf("got %d embedded messages, want 1", len(email.EmbeddedMessages))
+			}
+			embedded := email.EmbeddedMessages[0]
+			if embedded.PartID != "1" || embedded.Subject != "invoice" || embedded.Body != "Forwarded invoice" {
+				t.Errorf("embedded message = part %q, subject %q, body %q", embedded.PartID, embedded.Subject, embedded.Body)
+			}
+			if len(embedded.Attachments) != 1 || embedded.Attachments[0].Filename != "invoice.pdf" {
+				t.Errorf("embedded attachments = %+v, want invoice.pdf", embedded.Attachments)
+			}
+		})
+	}
+}
+
+func TestExtractContentNestedForwards(t *testing.T) {
+	payload := withChildren(testPart("", "message/rfc822"),
+		withChildren(testPart("0", "multipart/mixed", "Subject", "outer"),
+			withData(testPart("0.0", "text/plain"), "Outer"),
+			withChildren(testPart("0.1", "message/rfc822", "Content-Disposition", "attachment"),
+				withData(testPart("0.1.0", "text/plain", "Subject", "inner"), "Inner"),
+			),
+		),
+	)
+
+	email := &Email{}
+	extractContent(withChildren(testPart("", "multipart/mixed"), payload), email)
+
+	if len(email.EmbeddedMessages) != 1 {
+		t.Fatalf("got %d embedded messages, want 1", len(email.EmbeddedMessages))
+	}
+	outer := email.EmbeddedMessages[0]
+	if outer.Subject != "outer" || outer.Body != "Outer" {
+		t.Errorf("outer message = subject %q, body %q", outer.Subject, outer.Body)
+	}
+	if len(outer.EmbeddedMessages) != 1 {
+		t.Fatalf("got %d messages embedded in the outer message, want 1", len(outer.EmbeddedMessages))
+	}
+	if inner := outer.EmbeddedMessages[0]; inner.Subject != "inner" || inner.Body != "Inner" {
+		t.Errorf("inner message = subject %q, body %q", inner.Subject, inner.Body)
+	}
+}
+
+func TestExtractContentForwardedMultipleChildren(t *testing.T) {
+	// Without a single root, the children of the forwarded part are the
+	// content of the forwarded email and the part must not be embedded again
+	payload := withChildren(testPart("", "multipart/mixed"),
+		withData(testPart("0", "text/plain"), "See below"),
+		withChildren(testPart("1", "message/rfc822", "Subject", "invoice"),
+			withData(testPart("1.0", "text/plain"), "Forwarded invoice"),
+			withData(withFilename(testPart("1.1", "application/pdf", "Content-Disposition", "attachment"), "invoice.pdf"), "pdf"),
+		),
+	)
+
+	email := &Email{}
+	extractContent(payload, email)
+
+	if len(email.EmbeddedMessages) != 1 {
+		t.Fatalf("got %d embedded messages, want 1", len(email.EmbeddedMessages))
+	}
+	embedded := email.EmbeddedMessages[0]
+	if embedded.Subject != "invoice" || embedded.Body != "Forwarded invoice" {
+		t.Errorf("embedded message = subject %q, body %q", embedded.Subject, embedded.Body)
+	}
+	if len(embedded.EmbeddedMessages) != 0 {
+		t.Errorf("forwarded part embedded %d messages in itself", len(embedded.EmbeddedMessages))
+	}
+	if len(embedded.Attachments) != 1 || embedded.Attachments[0].Filename != "invoice.pdf" {
+		t.Errorf("embedded attachments = %+v, want invoice.pdf", embedded.Attachments)
+	}
+}
@@ -0,0 +1,141 @@
+package gmail
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sizePattern matches Gmail size values such as "500", "10K" or "5M"
+var sizePattern = regexp.MustCompile(`^[0-9]+[KkMm]?$`)
+
+// QueryFilter describes a Gmail search composed from structured criteria and
+// an optional raw query. All criteria must match.
+type QueryFilter struct {
+	// Query is raw Gmail search syntax, combined with the other criteria
+	Query string
+	// LabelIDs restricts the search to messages carrying every label
+	LabelIDs []string
+	// After and Before are dates formatted as YYYY-MM-DD or YYYY/MM/DD
+	After         string
+	Before        string
+	From          string
+	HasAttachment bool
+	// LargerThan is a size in bytes, optionally suffixed with K or M
+	LargerThan string
+}
+
+// Build validates the filter and returns the equivalent Gmail search query
+func (f QueryFilter) Build() (string, error) {
+	var terms []string
+
+	for _, labelID := range f.LabelIDs {
+		if labelID = strings.TrimSpace(labelID); labelID != "" {
+			terms = append(terms, "label:"+quoteTerm(labelID))
+		}
+	}
+
+	if f.From != "" {
+		terms = append(terms, "from:"+quoteTerm(f.From))
+	}
+
+	var after, before time.Time
+	if f.After != "" {
+		date, err := parseQueryDate(f.After)
+		if err != nil {
+			return "", fmt.Errorf("invalid after date: %w", err)
+		}
+		after = date
+		terms = append(terms, "after:"+date.Format("2006/01/02"))
+	}
+	if f.Before != "" {
+		date, err := parseQueryDate(f.Before)
+		if err != nil {
+			return "", fmt.Errorf("invalid before date: %w", err)
+		}
+		before = date
+		terms = append(terms, "before:"+date.Format("2006/01/02"))
+	}
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return "", fmt.Errorf("after date %s must be earlier than before date %s", f.After, f.Before)
+	}
+
+	if f.HasAttachment {
+		terms = append(terms, "has:attachment")
+	}
+
+	if f.LargerThan != "" {
+		if !sizePattern.MatchString(f.LargerThan) {
+			return "", fmt.Errorf("invalid size '%s', expected a number optionally followed by K or M", f.LargerThan)
+		}
+		terms = append(terms, "larger:"+strings.ToUpper(f.LargerThan))
+	}
+
+	if query := strings.TrimSpace(f.Query); query != "" {
+		if err := validateRawQuery(query); err != nil {
+			return "", err
+		}
+		// Group the raw query so that an OR inside it does not leak into
+		// the structured criteria
+		if len(terms) > 0 {
+			query = "(" + query + ")"
+		}
+		terms = append(terms, query)
+	}
+
+	return strings.Join(terms, " "), nil
+}
+
+// IsLabelOnly reports whether the filter selects a single label without any
+// other criteria
+func (f QueryFilter) IsLabelOnly() bool {
+	return len(f.LabelIDs) == 1 && f.Query == "" && f.After == "" && f.Before == "" &&
+		f.From == "" && !f.HasAttachment && f.LargerThan == ""
+}
+
+func parseQueryDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a YYYY-MM-DD date", value)
+}
+
+// quoteTerm quotes a search value containing whitespace
+func quoteTerm(value string) string {
+	if strings.ContainsAny(value, " \t") {
+		return `"` + strings.ReplaceAll(value, `"`, "") + `"`
+	}
+	return value
+}
+
+// validateRawQuery checks that quotes and parentheses are balanced
+func validateRawQuery(query string) error {
+	depth := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("invalid query '%s': unbalanced parentheses", query)
+			}
+		}
+	}
+
+	if inQuote {
+		return fmt.Errorf("invalid query '%s': unterminated quote", query)
+	}
+	if depth != 0 {
+		return fmt.Errorf("invalid query '%s': unbalanced parentheses", query)
+	}
+
+	return nil
+}
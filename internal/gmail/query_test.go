@@ -0,0 +1,109 @@
+package gmail
+
+import "testing"
+
+func TestQueryFilterBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter QueryFilter
+		want   string
+	}{
+		{"empty", QueryFilter{}, ""},
+		{"label", QueryFilter{LabelIDs: []string{"INBOX"}}, "label:INBOX"},
+		{"several labels", QueryFilter{LabelIDs: []string{"INBOX", " ", "My Label"}}, `label:INBOX label:"My Label"`},
+		{"from with spaces", QueryFilter{From: `John "JD" Doe`}, `from:"John JD Doe"`},
+		{"dates", QueryFilter{After: "2024-01-01", Before: "2024/02/01"}, "after:2024/01/01 before:2024/02/01"},
+		{"attachment and size", QueryFilter{HasAttachment: true, LargerThan: "5m"}, "has:attachment larger:5M"},
+		{"raw query alone", QueryFilter{Query: "  from:a OR from:b  "}, "from:a OR from:b"},
+		{"raw query grouped", QueryFilter{LabelIDs: []string{"INBOX"}, Query: "from:a OR from:b"}, "label:INBOX (from:a OR from:b)"},
+		{"everything", QueryFilter{
+			LabelIDs:      []string{"Label_1"},
+			From:          "billing@vendor.com",
+			After:         "2024-01-01",
+			Before:        "2025-01-01",
+			HasAttachment: true,
+			LargerThan:    "100K",
+			Query:         "filename:pdf",
+		}, "label:Label_1 from:billing@vendor.com after:2024/01/01 before:2025/01/01 has:attachment larger:100K (filename:pdf)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Build()
+			if err != nil {
+				t.Fatalf("Build() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Build() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryFilterBuildInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter QueryFilter
+	}{
+		{"invalid after", QueryFilter{After: "01/02/2024"}},
+		{"invalid before", QueryFilter{Before: "2024-13-01"}},
+		{"after not before", QueryFilter{After: "2024-02-01", Before: "2024-02-01"}},
+		{"invalid size", QueryFilter{LargerThan: "5G"}},
+		{"negative size", QueryFilter{LargerThan: "-5"}},
+		{"unbalanced query", QueryFilter{Query: "(from:a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.filter.Build(); err == nil {
+				t.Errorf("Build() = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestValidateRawQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"from:a", false},
+		{"(from:a OR from:b) has:attachment", false},
+		{`subject:"(draft"`, false},
+		{`subject:"a" (b)`, false},
+		{"((from:a)", true},
+		{"from:a)", true},
+		{")from:a(", true},
+		{`subject:"unterminated`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if err := validateRawQuery(tt.query); (err != nil) != tt.wantErr {
+				t.Errorf("validateRawQuery(%q) error = %v, want error %t", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueryFilterIsLabelOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter QueryFilter
+		want   bool
+	}{
+		{"single label", QueryFilter{LabelIDs: []string{"INBOX"}}, true},
+		{"no label", QueryFilter{}, false},
+		{"two labels", QueryFilter{LabelIDs: []string{"INBOX", "STARRED"}}, false},
+		{"label and query", QueryFilter{LabelIDs: []string{"INBOX"}, Query: "from:a"}, false},
+		{"label and size", QueryFilter{LabelIDs: []string{"INBOX"}, LargerThan: "1M"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.IsLabelOnly(); got != tt.want {
+				t.Errorf("IsLabelOnly() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
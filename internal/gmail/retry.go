@@ -0,0 +1,196 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultMaxRetries is the number of times a failed request is retried when
+// no retry count is configured
+const DefaultMaxRetries = 5
+
+// DefaultQuotaRate is the Gmail per-user quota, in quota units per second
+const DefaultQuotaRate = 250
+
+// Quota units consumed by each Gmail API method
+const (
+	quotaLabelsList     = 1
+	quotaGetProfile     = 1
+	quotaHistoryList    = 2
+	quotaMessagesList   = 5
+	quotaMessagesGet    = 5
+	quotaAttachmentsGet = 5
+	quotaThreadsList    = 10
+	quotaThreadsGet     = 10
+)
+
+const (
+	initialRetryDelay = time.Second
+	maxRetryDelay     = time.Minute
+	// retryAfterMaxSeconds caps the delay requested by a Retry-After header
+	retryAfterMaxSeconds = 300
+)
+
+// rateLimiter is a token bucket refilled at a fixed number of quota units per
+// second, holding at most one second worth of units
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until cost quota units are available or ctx is cancelled. A
+// limiter with a non-positive rate never blocks.
+func (l *rateLimiter) Wait(ctx context.Context, cost int) error {
+	if l == nil || l.rate <= 0 {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Reserve the units immediately, the bucket may go negative so that
+	// concurrent callers queue up behind each other
+	l.tokens -= float64(cost)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, delay)
+}
+
+// withRetry calls fn after waiting for cost quota units, retrying rate limit
+// errors, server errors and network failures with jittered exponential
+// backoff. A Retry-After header sent by the server takes precedence over the
+// computed delay.
+func withRetry[T any](ctx context.Context, c *Client, cost int, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx, cost); err != nil {
+			var zero T
+			return zero, err
+		}
+
+		result, err := fn()
+		if err == nil || attempt >= c.maxRetries || !isRetryable(ctx, err) {
+			return result, err
+		}
+
+		delay := retryDelay(err, attempt)
+		slog.Debug("Retrying request", "attempt", attempt+1, "delay", delay, "error", err)
+		if err := sleep(ctx, delay); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+}
+
+// isRetryable reports whether a failed request may succeed when retried.
+// Nothing is retried once the context is done.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		case http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return isTransientNetError(err)
+}
+
+// isTransientNetError reports whether a transport error is a timeout, a
+// reset or refused connection, or a truncated response. Other transport
+// errors, such as TLS failures or unknown hosts, persist when retried.
+func isTransientNetError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryDelay returns how long to wait before the given retry attempt
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Header != nil {
+		if delay, ok := parseRetryAfter(apiErr.Header.Get("Retry-After")); ok {
+			return delay
+		}
+	}
+
+	delay := min(initialRetryDelay<<min(attempt, 16), maxRetryDelay)
+	// Full jitter in the upper half of the window spreads out the retries
+	// of concurrent workers
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(min(seconds, retryAfterMaxSeconds)) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return min(max(time.Until(date), 0), retryAfterMaxSeconds*time.Second), true
+	}
+
+	return 0, false
+}
+
+// sleep waits for the given duration or until ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+package gmail
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsRetryable(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://gmail.googleapis.com", Err: err}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"server error", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"quota exceeded", &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, true},
+		{"forbidden", &googleapi.Error{Code: http.StatusForbidden}, false},
+		{"not found", &googleapi.Error{Code: http.StatusNotFound}, false},
+		{"timeout", urlError(os.ErrDeadlineExceeded), true},
+		{"connection reset", urlError(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"connection refused", urlError(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), true},
+		{"truncated response", fmt.Errorf("failed to read body: %w", io.ErrUnexpectedEOF), true},
+		{"unknown host", urlError(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "gmail.googleapis.com", IsNotFound: true}}), false},
+		{"certificate", urlError(x509.UnknownAuthorityError{}), false},
+		{"canceled", urlError(context.Canceled), false},
+		{"other", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(context.Background(), tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryableCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if isRetryable(ctx, &googleapi.Error{Code: http.StatusServiceUnavailable}) {
+		t.Error("isRetryable retried a request of a canceled context")
+	}
+}
@@ -0,0 +1,197 @@
+package gmail
+
+//go:generate go run ../../cmd/schema --output ../../schema/jsonl.schema.json
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// SchemaVersion is written in the schema_version field of every JSONL
+// record. It is incremented when a field is removed or changes meaning, new
+// optional fields do not change it.
+const SchemaVersion = 1
+
+// schemaID identifies the JSON Schema of JSONL records
+const schemaID = "https://github.com/f-pisani/gmail-cli-tools/schema/jsonl.schema.json"
+
+// JSONSchema returns the JSON Schema describing the records of JSONL
+// exports, generated from the record types. A record is an email, a thread
+// or an incremental export event.
+func JSONSchema() ([]byte, error) {
+	defs := make(map[string]any)
+	records := []any{}
+	for _, record := range []any{JSONLEmail{}, JSONLThread{}, JSONLEvent{}} {
+		records = append(records, typeSchema(reflect.TypeOf(record), defs))
+	}
+
+	schema := map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         schemaID,
+		"title":       "Gmail JSONL export record",
+		"description": fmt.Sprintf("An email, thread or event record of a JSONL export, schema version %d", SchemaVersion),
+		"oneOf":       records,
+		"$defs":       defs,
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// typeSchema returns the schema of a Go type as encoded by encoding/json.
+// Structs are added to defs and referenced by name.
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), defs)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		// encoding/json writes nil slices as null
+		return map[string]any{"type": []string{"array", "null"}, "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+		// Register the name first so that recursive types terminate
+		defs[t.Name()] = nil
+
+		properties := make(map[string]any)
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitempty, ok := jsonField(field)
+			if !ok {
+				continue
+			}
+
+			if name == "schema_version" {
+				properties[name] = map[string]any{"const": SchemaVersion}
+			} else {
+				properties[name] = typeSchema(field.Type, defs)
+			}
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+
+		defs[t.Name()] = map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// jsonField returns the JSON name of an exported struct field and whether it
+// is omitted when empty
+func jsonField(field reflect.StructField) (string, bool, bool) {
+	if !field.IsExported() {
+		return "", false, false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(","+options+",", ",omitempty,"), true
+}
+
+// CompileSchema compiles the JSON Schema of JSONL records for validation
+func CompileSchema() (*jsonschema.Schema, error) {
+	data, err := JSONSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(schemaID, doc); err != nil {
+		return nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+	schema, err := compiler.Compile(schemaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile schema: %w", err)
+	}
+	return schema, nil
+}
+
+// ValidateJSONL validates every line of a JSONL export against the JSON
+// Schema, calling report with the 1-based line number of each invalid
+// record. Blank lines are ignored. It returns the number of records read
+// and the number of invalid ones.
+func ValidateJSONL(r io.Reader, report func(line int, err error)) (int, int, error) {
+	schema, err := CompileSchema()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reader := bufio.NewReader(r)
+	records, invalid := 0, 0
+	for line := 1; ; line++ {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return records, invalid, fmt.Errorf("failed to read line %d: %w", line, readErr)
+		}
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			records++
+			if err := validateRecord(schema, data); err != nil {
+				invalid++
+				report(line, err)
+			}
+		}
+
+		if readErr != nil {
+			return records, invalid, nil
+		}
+	}
+}
+
+func validateRecord(schema *jsonschema.Schema, data []byte) error {
+	record, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return schema.Validate(record)
+}
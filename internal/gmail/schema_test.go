@@ -0,0 +1,128 @@
+package gmail
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestSchemaFileUpToDate(t *testing.T) {
+	want, err := JSONSchema()
+	if err != nil {
+		t.Fatalf("JSONSchema returned error: %v", err)
+	}
+	got, err := os.ReadFile("../../schema/jsonl.schema.json")
+	if err != nil {
+		t.Fatalf("failed to read schema file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("schema/jsonl.schema.json is outdated, run go generate ./internal/gmail")
+	}
+}
+
+// testRecords returns an email, a thread and an event record as written by
+// JSONL exports
+func testRecords(t *testing.T) []string {
+	t.Helper()
+	msg := &gmail.Message{
+		Id:       "id",
+		ThreadId: "thread",
+		LabelIds: []string{"INBOX"},
+		Payload: &gmail.MessagePart{Headers: []*gmail.MessagePartHeader{
+			{Name: "To", Value: "bob@example.com"},
+		}},
+	}
+	email := &Email{
+		ID:          "id",
+		From:        "alice@example.com",
+		Subject:     "Hi",
+		Date:        time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		DateSource:  DateSourceHeader,
+		Body:        "Hello",
+		Attachments: []Attachment{{ID: "att", Filename: "a.pdf", MimeType: "application/pdf", Size: 3, PartID: "1", Role: PartRoleAttachment}},
+		EmbeddedMessages: []*Email{{
+			Subject:     "Forwarded",
+			Attachments: []Attachment{},
+			PartID:      "2",
+		}},
+	}
+
+	var records []string
+	for _, record := range []any{
+		convertToJSONL(msg, email),
+		convertThreadToJSONL("thread", []*gmail.Message{msg}, []*Email{email}),
+		newJSONLEvent(EventLabelsAdded, 42, msg, []string{"STARRED"}),
+	} {
+		data, err := json.Marshal(record)
+		if err != nil {
+			t.Fatalf("failed to marshal record: %v", err)
+		}
+		records = append(records, string(data))
+	}
+	return records
+}
+
+func TestValidateJSONL(t *testing.T) {
+	records := testRecords(t)
+	email := records[0]
+
+	// withField returns the email record with a field replaced, or removed
+	// when value is nil
+	withField := func(name string, value any) string {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(email), &record); err != nil {
+			t.Fatalf("failed to unmarshal record: %v", err)
+		}
+		if value == nil {
+			delete(record, name)
+		} else {
+			record[name] = value
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			t.Fatalf("failed to marshal record: %v", err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		wantRecords int
+		wantInvalid []int
+	}{
+		{"valid records", strings.Join(records, "\n") + "\n", 3, nil},
+		{"blank lines and missing final newline", "\n" + email + "\n\n  \n" + records[2], 2, nil},
+		{"empty", "", 0, nil},
+		{"not json", email + "\n{not json\n", 2, []int{2}},
+		{"missing required field", withField("subject", nil), 1, []int{1}},
+		{"unknown field", withField("extra", true), 1, []int{1}},
+		{"wrong type", withField("label_ids", "INBOX"), 1, []int{1}},
+		{"other schema version", withField("schema_version", SchemaVersion+1), 1, []int{1}},
+		{"invalid lines reported", email + "\n[]\n" + email + "\n42\n", 4, []int{2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var invalidLines []int
+			records, invalid, err := ValidateJSONL(strings.NewReader(tt.input), func(line int, err error) {
+				invalidLines = append(invalidLines, line)
+			})
+			if err != nil {
+				t.Fatalf("ValidateJSONL returned error: %v", err)
+			}
+			if records != tt.wantRecords || invalid != len(tt.wantInvalid) {
+				t.Errorf("ValidateJSONL() = %d records, %d invalid, want %d, %d", records, invalid, tt.wantRecords, len(tt.wantInvalid))
+			}
+			if !slices.Equal(invalidLines, tt.wantInvalid) {
+				t.Errorf("reported lines %v, want %v", invalidLines, tt.wantInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package gmail
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the normalized tables of a SQLite export. Child rows
+// are replaced whenever a message is exported again.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	id            TEXT PRIMARY KEY,
+	thread_id     TEXT NOT NULL,
+	subject       TEXT NOT NULL,
+	sender        TEXT NOT NULL,
+	date          TEXT NOT NULL,
+	body_text     TEXT NOT NULL,
+	body_html     TEXT NOT NULL,
+	body_markdown TEXT NOT NULL,
+	deleted       INTEGER NOT NULL DEFAULT 0,
+	exported_at   TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE INDEX IF NOT EXISTS messages_thread_id ON messages (thread_id);
+CREATE INDEX IF NOT EXISTS messages_date ON messages (date);
+
+CREATE TABLE IF NOT EXISTS recipients (
+	message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	kind       TEXT NOT NULL CHECK (kind IN ('from', 'to', 'cc', 'bcc')),
+	position   INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	address    TEXT NOT NULL,
+	PRIMARY KEY (message_id, kind, position)
+);
+CREATE INDEX IF NOT EXISTS recipients_address ON recipients (address);
+
+CREATE TABLE IF NOT EXISTS labels (
+	message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	label_id   TEXT NOT NULL,
+	PRIMARY KEY (message_id, label_id)
+);
+CREATE INDEX IF NOT EXISTS labels_label_id ON labels (label_id);
+
+CREATE TABLE IF NOT EXISTS headers (
+	message_id TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	name       TEXT NOT NULL,
+	value      TEXT NOT NULL,
+	PRIMARY KEY (message_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	message_id    TEXT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+	position      INTEGER NOT NULL,
+	attachment_id TEXT NOT NULL,
+	filename      TEXT NOT NULL,
+	mime_type     TEXT NOT NULL,
+	size          INTEGER NOT NULL,
+	PRIMARY KEY (message_id, position)
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5 (
+	message_id UNINDEXED,
+	subject,
+	body
+);
+`
+
+// sqliteWriter upserts emails into a SQLite database by message ID, so that
+// repeated exports update the same database. Writes are grouped in a
+// transaction committed at every flush.
+type sqliteWriter struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+func newSQLiteWriter(options ExportOptions) (*sqliteWriter, error) {
+	if options.Compression != CompressionNone {
+		return nil, fmt.Errorf("compression is not supported by the sqlite format")
+	}
+	if options.OutputFile == StdoutOutput {
+		return nil, fmt.Errorf("the sqlite format cannot write to the standard output")
+	}
+
+	outputDir := filepath.Dir(options.OutputFile)
+	if outputDir != "." && outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", options.OutputFile+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// A single connection keeps the pragmas and the open transaction together
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create database schema: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	return &sqliteWriter{db: db, tx: tx}, nil
+}
+
+func (w *sqliteWriter) Write(msg *gmail.Message, email *Email) error {
+	return w.savepoint(func() error { return w.writeMessage(msg, email) })
+}
+
+// savepoint runs fn within a savepoint of the open transaction, rolling back
+// its statements when it fails so that the next commit does not store a
+// partially written message
+func (w *sqliteWriter) savepoint(fn func() error) error {
+	if _, err := w.tx.Exec("SAVEPOINT write"); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+
+	if err := fn(); err != nil {
+		if _, rollbackErr := w.tx.Exec("ROLLBACK TO write"); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back to savepoint: %w", rollbackErr))
+		}
+		if _, releaseErr := w.tx.Exec("RELEASE write"); releaseErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to release savepoint: %w", releaseErr))
+		}
+		return err
+	}
+
+	if _, err := w.tx.Exec("RELEASE write"); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
+func (w *sqliteWriter) writeMessage(msg *gmail.Message, email *Email) error {
+	record := convertToJSONL(msg, email)
+
+	body := record.Body.Text
+	if email.Body == htmlOnlyBody {
+		body = record.Body.Markdown
+	}
+
+	_, err := w.tx.Exec(`
+		INSERT INTO messages (id, thread_id, subject, sender, date, body_text, body_html, body_markdown, deleted)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0)
+		ON CONFLICT (id) DO UPDATE SET
+			thread_id = excluded.thread_id,
+			subject = excluded.subject,
+			sender = excluded.sender,
+			date = excluded.date,
+			body_text = excluded.body_text,
+			body_html = excluded.body_html,
+			body_markdown = excluded.body_markdown,
+			deleted = 0,
+			exported_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')`,
+		record.ID, record.ThreadID, record.Subject, record.From, record.Date,
+		record.Body.Text, record.Body.HTML, record.Body.Markdown)
+	if err != nil {
+		return fmt.Errorf("failed to upsert message: %w", err)
+	}
+
+	for _, table := range []string{"recipients", "labels", "headers", "attachments", "messages_fts"} {
+		if _, err := w.tx.Exec("DELETE FROM "+table+" WHERE message_id = ?", record.ID); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+
+	recipients := map[string][]string{
+		"from": parseRecipients(record.From),
+		"to":   record.To,
+		"cc":   record.Cc,
+		"bcc":  record.Bcc,
+	}
+	for kind, addresses := range recipients {
+		for i, recipient := range addresses {
+			name, address := "", recipient
+			if parsed, err := mail.ParseAddress(recipient); err == nil {
+				name, address = parsed.Name, parsed.Address
+			}
+			if _, err := w.tx.Exec("INSERT INTO recipients (message_id, kind, position, name, address) VALUES (?, ?, ?, ?, ?)",
+				record.ID, kind, i, name, address); err != nil {
+				return fmt.Errorf("failed to insert recipient: %w", err)
+			}
+		}
+	}
+
+	for _, labelID := range record.LabelIDs {
+		if _, err := w.tx.Exec("INSERT OR IGNORE INTO labels (message_id, label_id) VALUES (?, ?)", record.ID, labelID); err != nil {
+			return fmt.Errorf("failed to insert label: %w", err)
+		}
+	}
+
+	for name, value := range record.Headers {
+		if _, err := w.tx.Exec("INSERT OR REPLACE INTO headers (message_id, name, value) VALUES (?, ?, ?)", record.ID, name, value); err != nil {
+			return fmt.Errorf("failed to insert header: %w", err)
+		}
+	}
+
+	for i, att := range record.Attachments {
+		if _, err := w.tx.Exec("INSERT INTO attachments (message_id, position, attachment_id, filename, mime_type, size) VALUES (?, ?, ?, ?, ?, ?)",
+			record.ID, i, att.ID, att.Filename, att.MimeType, att.Size); err != nil {
+			return fmt.Errorf("failed to insert attachment: %w", err)
+		}
+	}
+
+	// Forwarded emails are indexed with the email forwarding them
+	body += embeddedText(email.EmbeddedMessages)
+	if _, err := w.tx.Exec("INSERT INTO messages_fts (message_id, subject, body) VALUES (?, ?, ?)", record.ID, record.Subject, body); err != nil {
+		return fmt.Errorf("failed to index message: %w", err)
+	}
+
+	return nil
+}
+
+// embeddedText joins the subjects and bodies of forwarded emails, and of the
+// emails they forward
+func embeddedText(emails []*Email) string {
+	var sb strings.Builder
+	for _, email := range emails {
+		body := email.Body
+		if body == htmlOnlyBody {
+			body = email.MarkdownBody
+		}
+		sb.WriteString("\n\n" + email.Subject + "\n\n" + body)
+		sb.WriteString(embeddedText(email.EmbeddedMessages))
+	}
+	return sb.String()
+}
+
+// WriteEvent applies a deletion or label change reported by the History
+// API to the stored message
+func (w *sqliteWriter) WriteEvent(event JSONLEvent) error {
+	return w.savepoint(func() error { return w.writeEvent(event) })
+}
+
+func (w *sqliteWriter) writeEvent(event JSONLEvent) error {
+	var err error
+	switch event.Event {
+	case EventDeleted:
+		_, err = w.tx.Exec("UPDATE messages SET deleted = 1 WHERE id = ?", event.ID)
+	case EventLabelsAdded, EventLabelsRemoved:
+		// Labels can only reference stored messages
+		var exists bool
+		if err := w.tx.QueryRow("SELECT EXISTS (SELECT 1 FROM messages WHERE id = ?)", event.ID).Scan(&exists); err != nil || !exists {
+			return err
+		}
+
+		for _, labelID := range event.Changed {
+			if event.Event == EventLabelsAdded {
+				_, err = w.tx.Exec("INSERT OR IGNORE INTO labels (message_id, label_id) VALUES (?, ?)", event.ID, labelID)
+			} else {
+				_, err = w.tx.Exec("DELETE FROM labels WHERE message_id = ? AND label_id = ?", event.ID, labelID)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s event: %w", event.Event, err)
+	}
+	return nil
+}
+
+func (w *sqliteWriter) Flush() (int64, error) {
+	if err := w.tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	w.tx = tx
+
+	return 0, nil
+}
+
+func (w *sqliteWriter) Close() error {
+	if err := w.tx.Commit(); err != nil {
+		w.db.Close()
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return w.db.Close()
+}
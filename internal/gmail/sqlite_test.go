@@ -0,0 +1,67 @@
+package gmail
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestSQLiteWriteFailureRollsBackMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emails.db")
+	w, err := newSQLiteWriter(ExportOptions{OutputFile: path})
+	if err != nil {
+		t.Fatalf("newSQLiteWriter returned error: %v", err)
+	}
+
+	// Fail the label inserts of one message, after its message row
+	if _, err := w.tx.Exec(`CREATE TRIGGER fail_label BEFORE INSERT ON labels WHEN NEW.label_id = 'FAIL'
+		BEGIN SELECT RAISE(ABORT, 'label rejected'); END`); err != nil {
+		t.Fatalf("failed to create trigger: %v", err)
+	}
+
+	message := func(id string, labelIDs ...string) *gmail.Message {
+		return &gmail.Message{Id: id, ThreadId: id, LabelIds: labelIDs, Payload: &gmail.MessagePart{}}
+	}
+	if err := w.Write(message("ok", "INBOX"), &Email{ID: "ok", Subject: "kept"}); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if err := w.Write(message("failed", "INBOX", "FAIL"), &Email{ID: "failed", Subject: "rolled back"}); err == nil {
+		t.Fatal("Write succeeded despite the rejected label")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"messages", "labels", "messages_fts"} {
+		column := "message_id"
+		if table == "messages" {
+			column = "id"
+		}
+
+		var ids []string
+		rows, err := db.Query("SELECT DISTINCT " + column + " FROM " + table)
+		if err != nil {
+			t.Fatalf("failed to query %s: %v", table, err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatalf("failed to scan %s: %v", table, err)
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		if len(ids) != 1 || ids[0] != "ok" {
+			t.Errorf("%s holds rows of messages %q, want only \"ok\"", table, ids)
+		}
+	}
+}
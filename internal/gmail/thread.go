@@ -0,0 +1,73 @@
+package gmail
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// threadResource describes how to retrieve threads with the full details of
+// their messages
+func (c *Client) threadResource() resource[*gmail.Thread] {
+	user := "me"
+	return resource[*gmail.Thread]{
+		kind: "thread",
+		cost: quotaThreadsGet,
+		path: func(id string) string {
+			return "threads/" + id + "?format=full"
+		},
+		get: func(ctx context.Context, id string) (*gmail.Thread, error) {
+			return c.service.Users.Threads.Get(user, id).Format("full").Context(ctx).Do()
+		},
+	}
+}
+
+// StreamThreadsByQuery yields threads matching the query with the full
+// details of their messages, as soon as each page of results has been
+// fetched. The limit in options applies to threads rather than messages.
+// Iteration stops after the first error.
+func (c *Client) StreamThreadsByQuery(ctx context.Context, query string, options QueryOptions) iter.Seq2[*gmail.Thread, error] {
+	user := "me"
+	list := func(pageToken string, pageSize int64) ([]string, string, error) {
+		call := c.service.Users.Threads.List(user).Q(query).MaxResults(pageSize)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		response, err := withRetry(ctx, c, quotaThreadsList, func() (*gmail.ListThreadsResponse, error) {
+			return call.Context(ctx).Do()
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to retrieve threads: %v", err)
+		}
+
+		ids := make([]string, 0, len(response.Threads))
+		for _, thread := range response.Threads {
+			ids = append(ids, thread.Id)
+		}
+		return ids, response.NextPageToken, nil
+	}
+
+	return streamByQuery(ctx, "threads", list, c.fetchThreads, options)
+}
+
+// fetchThreads retrieves the given threads with the full details of their
+// messages, along with their raw RFC 822 source when raw messages are
+// requested
+func (c *Client) fetchThreads(ctx context.Context, ids []string) ([]*gmail.Thread, error) {
+	threads, err := fetchAll(ctx, c, c.threadResource(), ids)
+	if err != nil || !c.includeRaw {
+		return threads, err
+	}
+
+	var messages []*gmail.Message
+	for _, thread := range threads {
+		messages = append(messages, thread.Messages...)
+	}
+	if err := c.attachRaw(ctx, messages); err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
@@ -8,7 +8,7 @@ import (
 )
 
 type SupportedEnvTypes interface {
-	string | int64 | bool
+	string | int64 | bool | []string
 }
 
 func GetEnvWithDefault[T SupportedEnvTypes](key string, defaultValue T) T {
@@ -31,6 +31,14 @@ func GetEnvWithDefault[T SupportedEnvTypes](key string, defaultValue T) T {
 		var parsed bool
 		parsed, err = strconv.ParseBool(strings.ToLower(value))
 		result = parsed
+	case []string:
+		var parsed []string
+		for _, item := range strings.Split(value, ",") {
+			if trimmed := strings.TrimSpace(item); trimmed != "" {
+				parsed = append(parsed, trimmed)
+			}
+		}
+		result = parsed
 	default:
 		slog.Warn("unsupported environment variable type, using default value", "env", key, "default", defaultValue)
 		return defaultValue